@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+type Activation string
+
+const (
+	ActivationSigmoid   Activation = "sigmoid"
+	ActivationTanh      Activation = "tanh"
+	ActivationReLU      Activation = "relu"
+	ActivationLeakyReLU Activation = "leaky_relu"
+	ActivationSoftmax   Activation = "softmax"
+	ActivationLinear    Activation = "linear"
+)
+
+// Slope used by leaky ReLU for negative inputs
+const leakySlope = 0.01
+
+func validActivation(activation Activation) bool {
+	switch activation {
+	case ActivationSigmoid, ActivationTanh, ActivationReLU, ActivationLeakyReLU, ActivationSoftmax, ActivationLinear:
+		return true
+	}
+	return false
+}
+
+// Activation used by the given layer, layers without one fall back to sigmoid
+func layerActivation(layer int, net NeuralNet) Activation {
+	activation := net.OutputActivation
+	if layer < len(net.HiddenLayers) {
+		activation = ""
+		if layer < len(net.HiddenActivations) {
+			activation = net.HiddenActivations[layer]
+		}
+	}
+	if activation == "" {
+		return ActivationSigmoid
+	}
+	return activation
+}
+
+// Ensure every layer has a known activation, softmax is only allowed on the output layer
+func checkActivations(net NeuralNet) error {
+	if len(net.HiddenActivations) > len(net.HiddenLayers) {
+		return errors.New("more hidden activations than hidden layers")
+	}
+	for layer := 0; layer < len(net.HiddenLayers)+1; layer++ {
+		activation := layerActivation(layer, net)
+		if !validActivation(activation) {
+			return errors.New("unknown activation '" + string(activation) + "'")
+		}
+		if activation == ActivationSoftmax && layer < len(net.HiddenLayers) {
+			return errors.New("softmax can only be used on the output layer")
+		}
+	}
+	return nil
+}
+
+// Apply the activation to a layers weighted sums in place
+func activate(activation Activation, values []float64) {
+	switch activation {
+	case ActivationTanh:
+		for index, value := range values {
+			values[index] = math.Tanh(value)
+		}
+	case ActivationReLU:
+		for index, value := range values {
+			values[index] = math.Max(0, value)
+		}
+	case ActivationLeakyReLU:
+		for index, value := range values {
+			if value < 0 {
+				values[index] = value * leakySlope
+			}
+		}
+	case ActivationSoftmax:
+		highest := math.Inf(-1)
+		for _, value := range values {
+			highest = math.Max(highest, value)
+		}
+		total := 0.0
+		for index, value := range values {
+			values[index] = math.Exp(value - highest) // Shifted to avoid overflow
+			total += values[index]
+		}
+		for index := range values {
+			values[index] /= total
+		}
+	case ActivationLinear:
+	default:
+		for index, value := range values {
+			values[index] = sigmoid(value)
+		}
+	}
+}
+
+// Convert the gradient with respect to a layers output into the gradient with respect to its weighted sums
+func activationGradient(activation Activation, output []float64, gradient []float64) []float64 {
+	result := make([]float64, len(output))
+	switch activation {
+	case ActivationTanh:
+		for index, value := range output {
+			result[index] = gradient[index] * (1 - value*value)
+		}
+	case ActivationReLU:
+		for index, value := range output {
+			if value > 0 {
+				result[index] = gradient[index]
+			}
+		}
+	case ActivationLeakyReLU:
+		for index, value := range output {
+			if value > 0 {
+				result[index] = gradient[index]
+			} else {
+				result[index] = gradient[index] * leakySlope
+			}
+		}
+	case ActivationSoftmax:
+		dot := 0.0
+		for index, value := range output {
+			dot += gradient[index] * value
+		}
+		for index, value := range output {
+			result[index] = value * (gradient[index] - dot)
+		}
+	case ActivationLinear:
+		copy(result, gradient)
+	default:
+		for index, value := range output {
+			result[index] = gradient[index] * value * (1 - value)
+		}
+	}
+	return result
+}
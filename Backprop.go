@@ -0,0 +1,420 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+type TrainerConfig struct {
+	Epochs       int
+	BatchSize    int
+	LearningRate float64
+	Loss         string  // mse, cross_entropy
+	Optimizer    string  // sgd, adam
+	Momentum     float64 // Only used by sgd
+	Schedule     string  // constant, step, exponential, cosine
+	DecayRate    float64
+	DecaySteps   int
+}
+
+// Loss of a single sample, along with its gradient with respect to the net output
+type Loss interface {
+	Loss(output []float64, target []float64) float64
+	Gradient(output []float64, target []float64) []float64
+}
+
+type mseLoss struct{}
+
+func (mseLoss) Loss(output []float64, target []float64) float64 {
+	total := 0.0
+	for index := range output {
+		diff := output[index] - target[index]
+		total += diff * diff
+	}
+	return total / float64(len(output))
+}
+
+func (mseLoss) Gradient(output []float64, target []float64) []float64 {
+	gradient := make([]float64, len(output))
+	for index := range output {
+		gradient[index] = 2 * (output[index] - target[index]) / float64(len(output))
+	}
+	return gradient
+}
+
+// Binary cross-entropy applied to each output
+type crossEntropyLoss struct{}
+
+const lossEpsilon = 1e-12
+
+func clampProbability(p float64) float64 {
+	return math.Min(math.Max(p, lossEpsilon), 1-lossEpsilon)
+}
+
+func (crossEntropyLoss) Loss(output []float64, target []float64) float64 {
+	total := 0.0
+	for index := range output {
+		p := clampProbability(output[index])
+		total -= target[index]*math.Log(p) + (1-target[index])*math.Log(1-p)
+	}
+	return total / float64(len(output))
+}
+
+func (crossEntropyLoss) Gradient(output []float64, target []float64) []float64 {
+	gradient := make([]float64, len(output))
+	for index := range output {
+		p := clampProbability(output[index])
+		gradient[index] = (p - target[index]) / (p * (1 - p)) / float64(len(output))
+	}
+	return gradient
+}
+
+// Categorical cross-entropy, used when the outputs form a distribution (softmax)
+type categoricalCrossEntropyLoss struct{}
+
+func (categoricalCrossEntropyLoss) Loss(output []float64, target []float64) float64 {
+	total := 0.0
+	for index := range output {
+		total -= target[index] * math.Log(clampProbability(output[index]))
+	}
+	return total
+}
+
+func (categoricalCrossEntropyLoss) Gradient(output []float64, target []float64) []float64 {
+	gradient := make([]float64, len(output))
+	for index := range output {
+		gradient[index] = -target[index] / clampProbability(output[index])
+	}
+	return gradient
+}
+
+func getLoss(name string, output Activation) (Loss, error) {
+	switch name {
+	case "mse":
+		return mseLoss{}, nil
+	case "cross_entropy":
+		// Probabilities are only guaranteed between 0 and 1 with these outputs
+		switch output {
+		case ActivationSoftmax:
+			return categoricalCrossEntropyLoss{}, nil
+		case ActivationSigmoid:
+			return crossEntropyLoss{}, nil
+		}
+		return nil, errors.New("cross_entropy needs a sigmoid or softmax output activation, got '" + string(output) + "'")
+	}
+	return nil, errors.New("unknown loss '" + name + "'")
+}
+
+// Applies gradients to a flat parameter vector
+type Optimizer interface {
+	Update(params []float64, gradients []float64, learningRate float64)
+}
+
+type sgdOptimizer struct {
+	momentum float64
+	velocity []float64
+}
+
+func (opt *sgdOptimizer) Update(params []float64, gradients []float64, learningRate float64) {
+	if opt.velocity == nil {
+		opt.velocity = make([]float64, len(params))
+	}
+	for index := range params {
+		opt.velocity[index] = opt.momentum*opt.velocity[index] - learningRate*gradients[index]
+		params[index] += opt.velocity[index]
+	}
+}
+
+type adamOptimizer struct {
+	beta1 float64
+	beta2 float64
+	step  int
+	m     []float64
+	v     []float64
+}
+
+func (opt *adamOptimizer) Update(params []float64, gradients []float64, learningRate float64) {
+	if opt.m == nil {
+		opt.m = make([]float64, len(params))
+		opt.v = make([]float64, len(params))
+	}
+	opt.step++
+	correction1 := 1 - math.Pow(opt.beta1, float64(opt.step))
+	correction2 := 1 - math.Pow(opt.beta2, float64(opt.step))
+	for index := range params {
+		opt.m[index] = opt.beta1*opt.m[index] + (1-opt.beta1)*gradients[index]
+		opt.v[index] = opt.beta2*opt.v[index] + (1-opt.beta2)*gradients[index]*gradients[index]
+		mHat := opt.m[index] / correction1
+		vHat := opt.v[index] / correction2
+		params[index] -= learningRate * mHat / (math.Sqrt(vHat) + 1e-8)
+	}
+}
+
+func getOptimizer(config TrainerConfig) (Optimizer, error) {
+	switch config.Optimizer {
+	case "sgd":
+		return &sgdOptimizer{momentum: config.Momentum}, nil
+	case "adam":
+		return &adamOptimizer{beta1: 0.9, beta2: 0.999}, nil
+	}
+	return nil, errors.New("unknown optimizer '" + config.Optimizer + "'")
+}
+
+// Learning rate to use for the given epoch
+func scheduledLearningRate(config TrainerConfig, epoch int) (float64, error) {
+	switch config.Schedule {
+	case "constant", "":
+		return config.LearningRate, nil
+	case "step":
+		if config.DecaySteps <= 0 {
+			return 0, errors.New("step schedule requires DecaySteps > 0")
+		}
+		return config.LearningRate * math.Pow(config.DecayRate, float64(epoch/config.DecaySteps)), nil
+	case "exponential":
+		return config.LearningRate * math.Pow(config.DecayRate, float64(epoch)), nil
+	case "cosine":
+		if config.Epochs <= 0 {
+			return 0, errors.New("cosine schedule requires Epochs > 0")
+		}
+		return 0.5 * config.LearningRate * (1 + math.Cos(math.Pi*float64(epoch)/float64(config.Epochs))), nil
+	}
+	return 0, errors.New("unknown learning rate schedule '" + config.Schedule + "'")
+}
+
+// Flatten the weights and biases of every layer, each neuron is stored as its weights followed by its bias
+func flattenNet(net NeuralNet) []float64 {
+	params := make([]float64, 0)
+	for layer := 0; layer < len(net.HiddenLayers)+1; layer++ {
+		for _, neuron := range getLayerNeurons(layer, net) {
+			params = append(params, neuron.Weights...)
+			params = append(params, neuron.Bias)
+		}
+	}
+	return params
+}
+
+// Build a new net shaped like the template from a flat parameter vector
+func unflattenNet(template NeuralNet, params []float64) NeuralNet {
+	offset := 0
+	readLayer := func(neurons []Neuron) []Neuron {
+		layer := make([]Neuron, len(neurons))
+		for index, neuron := range neurons {
+			layer[index] = neuron
+			layer[index].Weights = append([]float64(nil), params[offset:offset+len(neuron.Weights)]...)
+			offset += len(neuron.Weights)
+			layer[index].Bias = params[offset]
+			offset++
+		}
+		return layer
+	}
+	net := template
+	net.HiddenLayers = make([][]Neuron, len(template.HiddenLayers))
+	for layer, neurons := range template.HiddenLayers {
+		net.HiddenLayers[layer] = readLayer(neurons)
+	}
+	net.OutputLayer = readLayer(template.OutputLayer)
+	return net
+}
+
+// Run a forward pass, keeping the activation of every layer (index 0 is the input)
+func forwardTrace(input []float64, net NeuralNet) [][]float64 {
+	activations := make([][]float64, 0, len(net.HiddenLayers)+2)
+	activations = append(activations, input)
+	for layer := 0; layer < len(net.HiddenLayers)+1; layer++ {
+		input = calculateLayer(input, layer, net)
+		activations = append(activations, input)
+	}
+	return activations
+}
+
+// Add the gradient of a single sample to the flat gradient vector, returning the sample loss
+func backpropagate(net NeuralNet, input []float64, target []float64, loss Loss, gradients []float64) float64 {
+	activations := forwardTrace(input, net)
+	output := activations[len(activations)-1]
+	// Error with respect to the pre-activation of the output layer
+	delta := activationGradient(layerActivation(len(net.HiddenLayers), net), output, loss.Gradient(output, target))
+	// Offset of each layer within the flat vector
+	layerCount := len(net.HiddenLayers) + 1
+	offsets := make([]int, layerCount)
+	offset := 0
+	for layer := 0; layer < layerCount; layer++ {
+		offsets[layer] = offset
+		for _, neuron := range getLayerNeurons(layer, net) {
+			offset += len(neuron.Weights) + 1
+		}
+	}
+	for layer := layerCount - 1; layer >= 0; layer-- {
+		neurons := getLayerNeurons(layer, net)
+		previous := activations[layer]
+		nextDelta := make([]float64, len(previous))
+		offset := offsets[layer]
+		for index, neuron := range neurons {
+			for weight := range neuron.Weights {
+				gradients[offset+weight] += delta[index] * previous[weight]
+				nextDelta[weight] += neuron.Weights[weight] * delta[index]
+			}
+			gradients[offset+len(neuron.Weights)] += delta[index]
+			offset += len(neuron.Weights) + 1
+		}
+		if layer > 0 {
+			delta = activationGradient(layerActivation(layer-1, net), previous, nextDelta)
+		}
+	}
+	return loss.Loss(output, target)
+}
+
+// Train a copy of the net against the given samples using mini-batch gradient descent
+func TrainNet(rng *rand.Rand, net NeuralNet, inputs [][]float64, targets [][]float64, config TrainerConfig, report func(epoch int, loss float64)) (NeuralNet, error) {
+	if config.Epochs <= 0 || config.BatchSize <= 0 {
+		return net, errors.New("epochs and batch size must be above 0")
+	}
+	if _, err := scheduledLearningRate(config, 0); err != nil {
+		return net, err
+	}
+	if len(inputs) == 0 || len(inputs) != len(targets) {
+		return net, errors.New("inputs and targets must be the same non zero length")
+	}
+	if net.Recurrent != nil {
+		return net, errors.New("backpropagation does not support recurrent layers, use the genetic algorithm")
+	}
+	topology := netTopology(net)
+	for index := range inputs {
+		if len(inputs[index]) != topology.InputSize || len(targets[index]) != topology.OutputSize {
+			return net, fmt.Errorf("sample %d does not match the net topology", index)
+		}
+	}
+	if err := checkActivations(net); err != nil {
+		return net, err
+	}
+	loss, err := getLoss(config.Loss, layerActivation(len(net.HiddenLayers), net))
+	if err != nil {
+		return net, err
+	}
+	optimizer, err := getOptimizer(config)
+	if err != nil {
+		return net, err
+	}
+	params := flattenNet(net)
+	net = unflattenNet(net, params)
+	order := make([]int, len(inputs))
+	for index := range order {
+		order[index] = index
+	}
+	for epoch := 0; epoch < config.Epochs; epoch++ {
+		learningRate, err := scheduledLearningRate(config, epoch)
+		if err != nil {
+			return net, err
+		}
+		rng.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+		epochLoss := 0.0
+		for start := 0; start < len(order); start += config.BatchSize {
+			end := start + config.BatchSize
+			if end > len(order) {
+				end = len(order)
+			}
+			gradients := make([]float64, len(params))
+			for _, sample := range order[start:end] {
+				epochLoss += backpropagate(net, inputs[sample], targets[sample], loss, gradients)
+			}
+			for index := range gradients {
+				gradients[index] /= float64(end - start)
+			}
+			optimizer.Update(params, gradients, learningRate)
+			net = unflattenNet(net, params)
+		}
+		if report != nil {
+			report(epoch, epochLoss/float64(len(order)))
+		}
+	}
+	return net, nil
+}
+
+// Convert market scores from a labeler into [nothing, buy, sell] targets, using the same bands as computeBotScore
+func labelTargets(points []float64) [][]float64 {
+	targets := make([][]float64, len(points))
+	for index, point := range points {
+		target := make([]float64, 3)
+		if point > .8 {
+			target[2] = 1
+		} else if point < .2 {
+			target[1] = 1
+		} else {
+			target[0] = 1
+		}
+		targets[index] = target
+	}
+	return targets
+}
+
+// Train a bot with backpropagation on the same window the genetic algorithm uses
+func runGradientTraining(settings BotSettings, sql *sql.DB, discord *discordgo.Session) {
+	trainingSettings, err := loadTrainingSettings()
+	if err != nil {
+		fmt.Println("Invalid training config, " + err.Error())
+		return
+	}
+	training = trainingSettings
+	pipeline := trainingPipeline(training)
+	start := getMarketStartingPoint(sql, settings.Market)
+	labeler, err := getLabeler(training)
+	if err != nil {
+		fmt.Println("Invalid labeler config, " + err.Error())
+		return
+	}
+	window := loadWindow(sql, settings, pipeline, labeler, start, start+training.GenerationWindow)
+	if len(window.History) == 0 {
+		fmt.Println("No market history found for " + settings.Market)
+		return
+	}
+	pipeline = pipeline.Fit(training, window.History)
+	window.Inputs = pipeline.Transform(window.History)
+	trainingSeed = pickSeed(training.Seed)
+	rng, _ := newTrainingRand(trainingSeed)
+	trained, err := TrainNet(rng, randomBot(rng, training), window.Inputs, labelTargets(window.Labels), training.Backprop, func(epoch int, loss float64) {
+		fmt.Printf("Epoch %d Loss %.8f \n", epoch, loss)
+	})
+	if err != nil {
+		fmt.Println("Gradient training failed, " + err.Error())
+		return
+	}
+	// Score with the same fitness as the genetic algorithm so the two can be compared
+	fitness, err := getFitness(training, loadBacktestConfig(settings, pipeline))
+	if err != nil {
+		fmt.Println("Invalid fitness config, " + err.Error())
+		return
+	}
+	score := fitness.Fitness(newBatchNet(trained, training.Float32), window)
+	info := fmt.Sprintf("Gradient training for %s complete, Score: %.8f", settings.Name, score)
+	if gaNet, gaMetadata, err := LoadNet(modelPath(settings.Name)); err == nil && sameFeatures(gaMetadata.FeatureSet, training.Features) {
+		// The genetic algorithm fitted its own normalizer
+		gaWindow := window
+		gaPipeline := pipeline
+		gaPipeline.Normalizer = gaMetadata.Normalizer
+		gaWindow.Inputs = gaPipeline.Transform(window.History)
+		info += fmt.Sprintf(" GA Score: %.8f", fitness.Fitness(newBatchNet(gaNet, training.Float32), gaWindow))
+	}
+	BotLog(discord, info)
+	fmt.Println(info)
+	err = SaveNet(modelPath(settings.Name+"-backprop"), trained, ModelMetadata{
+		Name:       settings.Name + "-backprop",
+		Market:     settings.Market,
+		Generation: training.Backprop.Epochs,
+		Fitness:    score,
+		Seed:       trainingSeed,
+		FeatureSet: training.Features,
+		Lookback:   training.Lookback,
+		Normalizer: pipeline.Normalizer,
+	})
+	if err != nil {
+		fmt.Println("Failed to save model, " + err.Error())
+	} else {
+		fmt.Println("Saved model to " + modelPath(settings.Name+"-backprop") + " after " + strconv.Itoa(training.Backprop.Epochs) + " epochs")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Loss of the net over every sample
+func datasetLoss(net NeuralNet, loss Loss, inputs [][]float64, targets [][]float64) float64 {
+	total := 0.0
+	for index, input := range inputs {
+		total += loss.Loss(Compute(input, net), targets[index])
+	}
+	return total / float64(len(inputs))
+}
+
+func TestBackpropMatchesFiniteDifferences(t *testing.T) {
+	pairs := []struct {
+		loss   string
+		output Activation
+	}{
+		{"mse", ActivationSigmoid},
+		{"mse", ActivationTanh},
+		{"mse", ActivationReLU},
+		{"mse", ActivationLeakyReLU},
+		{"mse", ActivationLinear},
+		{"mse", ActivationSoftmax},
+		{"cross_entropy", ActivationSigmoid},
+		{"cross_entropy", ActivationSoftmax},
+	}
+	hidden := []Activation{ActivationSigmoid, ActivationTanh, ActivationReLU, ActivationLeakyReLU, ActivationLinear}
+	const epsilon = 1e-6
+	for _, pair := range pairs {
+		for _, activation := range hidden {
+			rng := rand.New(newRngSource(7))
+			net := withActivations(RandomNet(rng, 4, 2, []int{5, 4}, 3), activation, pair.output)
+			// Small centered weights so no unit saturates, finite differences lose their precision on flat activations
+			params := flattenNet(net)
+			for index := range params {
+				params[index] = rng.NormFloat64() * 0.5
+			}
+			net = unflattenNet(net, params)
+			loss, err := getLoss(pair.loss, pair.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := []float64{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}
+			target := []float64{0, 1, 0}
+			gradients := make([]float64, len(flattenNet(net)))
+			backpropagate(net, input, target, loss, gradients)
+			for index := range params {
+				original := params[index]
+				params[index] = original + epsilon
+				above := loss.Loss(Compute(input, unflattenNet(net, params)), target)
+				params[index] = original - epsilon
+				below := loss.Loss(Compute(input, unflattenNet(net, params)), target)
+				params[index] = original
+				numeric := (above - below) / (2 * epsilon)
+				if math.Abs(numeric-gradients[index]) > 1e-5*math.Max(1, math.Abs(numeric)) {
+					t.Fatalf("%s with %s hidden and %s output: parameter %d has gradient %v, finite differences give %v",
+						pair.loss, activation, pair.output, index, gradients[index], numeric)
+				}
+			}
+		}
+	}
+}
+
+func TestCrossEntropyNeedsProbabilities(t *testing.T) {
+	for _, output := range []Activation{ActivationTanh, ActivationReLU, ActivationLeakyReLU, ActivationLinear} {
+		if _, err := getLoss("cross_entropy", output); err == nil {
+			t.Fatalf("cross_entropy accepted a %s output", output)
+		}
+	}
+}
+
+func TestTrainingEpochReducesLoss(t *testing.T) {
+	rng := rand.New(newRngSource(3))
+	// Buy when the first input is above the second, sell otherwise
+	inputs := make([][]float64, 256)
+	targets := make([][]float64, len(inputs))
+	for index := range inputs {
+		inputs[index] = []float64{rng.NormFloat64(), rng.NormFloat64()}
+		if inputs[index][0] > inputs[index][1] {
+			targets[index] = []float64{0, 1, 0}
+		} else {
+			targets[index] = []float64{0, 0, 1}
+		}
+	}
+	net := withActivations(RandomNet(rng, 2, 1, []int{8}, 3), ActivationTanh, ActivationSoftmax)
+	config := TrainerConfig{Epochs: 1, BatchSize: 16, LearningRate: 0.05, Loss: "cross_entropy", Optimizer: "adam", Schedule: "constant"}
+	loss, _ := getLoss(config.Loss, ActivationSoftmax)
+	before := datasetLoss(net, loss, inputs, targets)
+	trained, err := TrainNet(rng, net, inputs, targets, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := datasetLoss(trained, loss, inputs, targets); after >= before {
+		t.Fatalf("loss went from %v to %v after an epoch", before, after)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var backtestConfig viper.Viper
+
+type BacktestConfig struct {
+	Fee           float64 // Fraction of each fill charged as a fee
+	Slippage      float64 // Fraction each fill price moves against the bot
+	StartingQuote float64
+	StartingBase  float64
+	Settings      BotSettings
+	Product       Product         // Order size limits, sizes are rounded down to the minimum size
+	Pipeline      FeaturePipeline // Turns candles into the inputs of the bot
+	Float32       bool            // Run the bot in single precision
+}
+
+type BacktestTrade struct {
+	Timestamp int64
+	Side      string
+	Price     float64
+	Size      float64
+	Fee       float64
+}
+
+type BacktestResult struct {
+	Trades         []BacktestTrade
+	Timestamps     []int64   // Timestamp of each candle
+	Prices         []float64 // Close of each candle
+	Equity         []float64 // Quote value of the portfolio after each candle
+	Base           []float64 // Base currency held after each candle
+	StartingPrice  float64
+	StartingEquity float64
+	FinalQuote     float64
+	FinalBase      float64
+	FinalEquity    float64
+}
+
+// Limit order waiting for the next candle
+type pendingOrder struct {
+	side  string
+	price float64
+	size  float64
+}
+
+func readBacktestConfig() viper.Viper {
+	backtestConfig := viper.New()
+	backtestConfig.SetConfigName("backtest")
+	backtestConfig.SetConfigType("json")
+	backtestConfig.AddConfigPath(BaseDir)
+	// Set Defaults
+	fee, _ := feePerc.Float64()
+	backtestConfig.SetDefault("fee", fee)
+	backtestConfig.SetDefault("slippage", 0.0005)
+	backtestConfig.SetDefault("starting_quote", 1000.0)
+	backtestConfig.SetDefault("starting_base", 0.0)
+	backtestConfig.SetDefault("base_min_size", 0.0001)
+	backtestConfig.SetDefault("base_max_size", 0.0) // 0 for no limit
+	// Read config
+	if err := backtestConfig.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			backtestConfig.SafeWriteConfig()
+		} else {
+			panic(err)
+		}
+	}
+	return *backtestConfig
+}
+
+// Backtest settings for the given bot
+func loadBacktestConfig(settings BotSettings, pipeline FeaturePipeline) BacktestConfig {
+	backtestConfig = readBacktestConfig()
+	return BacktestConfig{
+		Fee:           backtestConfig.GetFloat64("fee"),
+		Slippage:      backtestConfig.GetFloat64("slippage"),
+		StartingQuote: backtestConfig.GetFloat64("starting_quote"),
+		StartingBase:  backtestConfig.GetFloat64("starting_base"),
+		Settings:      settings,
+		Product: Product{
+			Market:      settings.Market,
+			BaseMinSize: decimal.NewFromFloat(backtestConfig.GetFloat64("base_min_size")),
+			BaseMaxSize: decimal.NewFromFloat(backtestConfig.GetFloat64("base_max_size")),
+		},
+		Pipeline: pipeline,
+	}
+}
+
+// Index of the strongest output, 0 Nothing, 1 Buy, 2 Sell
+func netAction(output []float64) int {
+	action := 0
+	for index := range output {
+		if output[index] > output[action] {
+			action = index
+		}
+	}
+	return action
+}
+
+// Replay the history through a bot, placing limit orders that fill on the following candle if the price reaches them
+func Backtest(net NeuralNet, history []HistoricalEntry, config BacktestConfig) (BacktestResult, error) {
+	candles := append([]HistoricalEntry(nil), history...)
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].timestamp < candles[j].timestamp
+	})
+	return backtestInputs(newBatchNet(net, config.Float32), candles, config.Pipeline.Transform(candles), config)
+}
+
+// Backtest on candles sorted oldest first, with their inputs already computed
+func backtestInputs(net BatchNet, candles []HistoricalEntry, inputs [][]float64, config BacktestConfig) (BacktestResult, error) {
+	if len(candles) == 0 {
+		return BacktestResult{}, errors.New("no history to backtest on")
+	}
+	sizer, err := getPositionSizer(config.Settings)
+	if err != nil {
+		return BacktestResult{}, err
+	}
+	outputs := net.Forward(inputs)
+	quote := config.StartingQuote
+	base := config.StartingBase
+	result := BacktestResult{
+		Trades:         make([]BacktestTrade, 0),
+		Timestamps:     make([]int64, len(candles)),
+		Prices:         make([]float64, len(candles)),
+		Equity:         make([]float64, len(candles)),
+		Base:           make([]float64, len(candles)),
+		StartingPrice:  candles[0].firstTradePrice,
+		StartingEquity: quote + base*candles[0].firstTradePrice,
+	}
+	var pending *pendingOrder
+	for index, candle := range candles {
+		// Fill the previous candles order if the price crossed it
+		if pending != nil {
+			if pending.side == "buy" && candle.lowestPrice <= pending.price {
+				price := pending.price * (1 + config.Slippage)
+				size := math.Min(pending.size, quote/(price*(1+config.Fee)))
+				if size > 0 {
+					fee := size * price * config.Fee
+					quote -= size*price + fee
+					base += size
+					result.Trades = append(result.Trades, BacktestTrade{Timestamp: candle.timestamp, Side: "buy", Price: price, Size: size, Fee: fee})
+				}
+			} else if pending.side == "sell" && candle.highestPrice >= pending.price {
+				price := pending.price * (1 - config.Slippage)
+				size := math.Min(pending.size, base)
+				if size > 0 {
+					fee := size * price * config.Fee
+					quote += size*price - fee
+					base -= size
+					result.Trades = append(result.Trades, BacktestTrade{Timestamp: candle.timestamp, Side: "sell", Price: price, Size: size, Fee: fee})
+				}
+			}
+			pending = nil
+		}
+		// Let the bot decide on this candle
+		switch netAction(outputs[index]) {
+		case 1:
+			pending = backtestOrder(sizer, "buy", candle.lastTradePrice*(1-config.Settings.MarginBuy), quote, base, candles[:index+1], config)
+		case 2:
+			pending = backtestOrder(sizer, "sell", candle.lastTradePrice*(1+config.Settings.MarginSell), quote, base, candles[:index+1], config)
+		}
+		result.Timestamps[index] = candle.timestamp
+		result.Prices[index] = candle.lastTradePrice
+		result.Equity[index] = quote + base*candle.lastTradePrice
+		result.Base[index] = base
+	}
+	result.FinalQuote = quote
+	result.FinalBase = base
+	result.FinalEquity = result.Equity[len(result.Equity)-1]
+	return result, nil
+}
+
+// Size an order for the candles up to the current one, nil when no order is placed
+func backtestOrder(sizer PositionSizer, side string, price float64, quote float64, base float64, candles []HistoricalEntry, config BacktestConfig) *pendingOrder {
+	size, err := sizeOrder(sizer, SizingContext{
+		Side:           side,
+		Price:          price,
+		QuoteAvailable: quote,
+		BaseAvailable:  base,
+		Equity:         quote + base*candles[len(candles)-1].lastTradePrice,
+		Candles:        candles,
+	}, config.Product)
+	if err != nil || size.IsZero() {
+		return nil
+	}
+	amount, _ := size.Float64()
+	return &pendingOrder{side: side, price: price, size: amount}
+}
+
+// Load candles from a csv file with the columns timestamp, low, high, open, close, volume (a header row is optional)
+func loadHistoryCSV(path string, market string) ([]HistoricalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 6
+	history := make([]HistoricalEntry, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		values := make([]float64, len(record))
+		for index, field := range record {
+			values[index], err = strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			if line == 1 { // Header
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		history = append(history, HistoricalEntry{
+			exchange:        "csv",
+			market:          market,
+			timestamp:       int64(values[0]),
+			lowestPrice:     values[1],
+			highestPrice:    values[2],
+			firstTradePrice: values[3],
+			lastTradePrice:  values[4],
+			volume:          values[5],
+		})
+	}
+	return history, nil
+}
+
+// Write the trades of a backtest to a csv file
+func writeTradeLog(path string, trades []BacktestTrade) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"timestamp", "side", "price", "size", "fee"})
+	for _, trade := range trades {
+		writer.Write([]string{
+			strconv.FormatInt(trade.Timestamp, 10),
+			trade.Side,
+			strconv.FormatFloat(trade.Price, 'f', -1, 64),
+			strconv.FormatFloat(trade.Size, 'f', -1, 64),
+			strconv.FormatFloat(trade.Fee, 'f', -1, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/shopspring/decimal"
+	"math"
+	"testing"
+)
+
+// Net taking a fixed action on each candle, 0 Nothing, 1 Buy, 2 Sell
+type scriptedNet []int
+
+func (actions scriptedNet) Forward(inputs [][]float64) [][]float64 {
+	outputs := make([][]float64, len(inputs))
+	for index := range outputs {
+		outputs[index] = make([]float64, 3)
+		outputs[index][actions[index]] = 1
+	}
+	return outputs
+}
+
+// Candles where a buy at 99 fills on the second candle, a sell at 103.02 on the third and a buy at 101.97 never fills
+func backtestFixture() []HistoricalEntry {
+	return []HistoricalEntry{
+		{timestamp: 60, lowestPrice: 99, highestPrice: 101, firstTradePrice: 100, lastTradePrice: 100},
+		{timestamp: 120, lowestPrice: 98, highestPrice: 102, firstTradePrice: 100, lastTradePrice: 102},
+		{timestamp: 180, lowestPrice: 101, highestPrice: 104, firstTradePrice: 102, lastTradePrice: 103},
+		{timestamp: 240, lowestPrice: 102, highestPrice: 103, firstTradePrice: 103, lastTradePrice: 101},
+	}
+}
+
+func backtestFixtureConfig(slippage float64) BacktestConfig {
+	return BacktestConfig{
+		Fee:           0.01,
+		Slippage:      slippage,
+		StartingQuote: 1000,
+		Settings:      BotSettings{MarginBuy: 0.01, MarginSell: 0.01, AmountCalculationType: "fixed_base", AmountData: "1"},
+		Product:       Product{BaseMinSize: decimal.RequireFromString("0.01")},
+	}
+}
+
+func closeTo(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
+
+func TestBacktestFillsAndFees(t *testing.T) {
+	candles := backtestFixture()
+	result, err := backtestInputs(scriptedNet{1, 2, 1, 0}, candles, make([][]float64, len(candles)), backtestFixtureConfig(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Buy 1 at 100 * 0.99 paying 1% fee, then sell 1 at 102 * 1.01 paying 1% fee
+	expected := []BacktestTrade{
+		{Timestamp: 120, Side: "buy", Price: 99, Size: 1, Fee: 0.99},
+		{Timestamp: 180, Side: "sell", Price: 103.02, Size: 1, Fee: 1.0302},
+	}
+	if len(result.Trades) != len(expected) {
+		t.Fatalf("expected %d trades, got %+v", len(expected), result.Trades)
+	}
+	for index, trade := range result.Trades {
+		want := expected[index]
+		if trade.Timestamp != want.Timestamp || trade.Side != want.Side || !closeTo(trade.Price, want.Price) || !closeTo(trade.Size, want.Size) || !closeTo(trade.Fee, want.Fee) {
+			t.Fatalf("trade %d: expected %+v, got %+v", index, want, trade)
+		}
+	}
+	// 1000 - 99 - 0.99 = 900.01 quote after the buy, + 103.02 - 1.0302 = 1001.9998 after the sell
+	equity := []float64{1000, 900.01 + 102, 1001.9998, 1001.9998}
+	base := []float64{0, 1, 0, 0}
+	for index := range candles {
+		if !closeTo(result.Equity[index], equity[index]) || !closeTo(result.Base[index], base[index]) {
+			t.Fatalf("candle %d: expected equity %v holding %v, got %v holding %v", index, equity[index], base[index], result.Equity[index], result.Base[index])
+		}
+	}
+	if result.StartingEquity != 1000 || !closeTo(result.FinalQuote, 1001.9998) || result.FinalBase != 0 || !closeTo(result.FinalEquity, 1001.9998) {
+		t.Fatalf("unexpected final state %+v", result)
+	}
+}
+
+func TestBacktestSlippage(t *testing.T) {
+	candles := backtestFixture()
+	result, err := backtestInputs(scriptedNet{1, 2, 0, 0}, candles, make([][]float64, len(candles)), backtestFixtureConfig(0.01))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Trades) != 2 {
+		t.Fatalf("expected 2 trades, got %+v", result.Trades)
+	}
+	// Fills move 1% against the bot, the fee is charged on the slipped price
+	buy, sell := result.Trades[0], result.Trades[1]
+	if !closeTo(buy.Price, 99.99) || !closeTo(buy.Fee, 0.9999) || !closeTo(sell.Price, 101.9898) || !closeTo(sell.Fee, 1.019898) {
+		t.Fatalf("unexpected fills %+v", result.Trades)
+	}
+	if !closeTo(result.FinalQuote, 1000-99.99-0.9999+101.9898-1.019898) {
+		t.Fatalf("unexpected final quote %v", result.FinalQuote)
+	}
+}
+
+func TestBacktestBuyLimitedByQuote(t *testing.T) {
+	candles := backtestFixture()
+	config := backtestFixtureConfig(0)
+	config.StartingQuote = 50
+	config.Settings.AmountCalculationType = "percent_balance"
+	config.Settings.AmountData = "100"
+	result, err := backtestInputs(scriptedNet{1, 0, 0, 0}, candles, make([][]float64, len(candles)), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Trades) != 1 {
+		t.Fatalf("expected 1 trade, got %+v", result.Trades)
+	}
+	// The sizer leaves room for the configured fee, the fill never spends more than the quote held
+	trade := result.Trades[0]
+	if trade.Size*trade.Price+trade.Fee > 50+1e-9 || result.FinalQuote < 0 {
+		t.Fatalf("spent more than the quote held, %+v leaves %v", trade, result.FinalQuote)
+	}
+}
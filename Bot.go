@@ -10,6 +10,19 @@ type BotSettings struct {
 	AmountData            string
 }
 
+// Settings used for a bot until per bot configuration exists
+func defaultBotSettings(name string) BotSettings {
+	return BotSettings{
+		Name:                  name,
+		Market:                "BTC-USD",
+		UpdateTime:            300,
+		MarginSell:            0.01,
+		MarginBuy:             0.01,
+		AmountCalculationType: "SetCurrency",
+		AmountData:            "5",
+	}
+}
+
 type BotGenerationScore struct {
 	Bot   NeuralNet
 	score float64
@@ -1,55 +1,199 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	. "fmt"
 	"github.com/bwmarrin/discordgo"
-	"github.com/preichenberger/go-coinbasepro/v2"
 	"math/rand"
 	"strconv"
 	"time"
 )
 
 // ML Data
-const botCount = 100
-
+var training TrainingSettings
+var trainingSeed int64
 var generation = 0
 var bestBot NeuralNet
+var normalizer Normalizer
 var bots []NeuralNet
 var bestFitness = 0.0
+var bestValidationFitness = 0.0
 
-// Training data
-const generationTimeframe = 24
+// Bring the market history up to date, then train the bot while trading with its best saved model
+func startBot(command chan string, exchange Exchange, settings BotSettings, sql *sql.DB) {
+	discord := StartupDiscordBot()
+	// Paper trading has no market data of its own, it trades on the history of its price source
+	recorder := exchange
+	if paper, ok := exchange.(*paperExchange); ok {
+		recorder = paper.source
+	}
+	if recorder != nil {
+		updateMarketHistory(recorder, settings, sql, discord)
+	}
+	Println("Bot Initalization Complete")
+	go run(exchange, settings, sql, discord)
+	go trade(exchange, settings, discord)
+}
 
-func run(coinbase *coinbasepro.Client, settings BotSettings, sql *sql.DB, discord *discordgo.Session) {
+func run(exchange Exchange, settings BotSettings, sql *sql.DB, discord *discordgo.Session) {
 	BotLog(discord, settings.Name+" Bot Starting on '"+settings.Market+"'")
 	Println(settings.Name + " Bot Starting on '" + settings.Market + "'")
+	trainingSettings, err := loadTrainingSettings()
+	if err != nil {
+		BotLog(discord, settings.Name+" Bot refusing to start, invalid training config: "+err.Error())
+		Println("Invalid training config, " + err.Error())
+		return
+	}
+	training = trainingSettings
 	startPoint := getMarketStartingPoint(sql, settings.Market)
 	// Setup ML
-	bots := createRandomBots()
+	trainingSeed = pickSeed(training.Seed)
+	Println("Training with seed " + strconv.FormatInt(trainingSeed, 10))
+	rng, source := newTrainingRand(trainingSeed)
+	bots = createRandomBots(rng)
+	// Scale inputs by the history the first generations can be scored on, so nothing is learned from the validation data
+	labeler, err := getLabeler(training)
+	if err != nil {
+		Println("Invalid labeler config, " + err.Error())
+		return
+	}
+	strategy, err := getWindowStrategy(training, sql, settings, trainingPipeline(training), labeler, startPoint, getMarketEndPoint(sql, settings.Market))
+	if err != nil {
+		Println("Invalid window config, " + err.Error())
+		return
+	}
+	normalizer = fitWindowNormalizer(sql, settings, strategy, startPoint)
+	// Continue training from a previously saved best bot
+	if net, metadata, err := LoadNet(modelPath(settings.Name)); err != nil {
+		Println("No saved model loaded for " + settings.Name + ", " + err.Error())
+	} else if err := checkTopology(net, trainingTopology(training)); err != nil {
+		Println("Ignoring saved model for " + settings.Name + ", " + err.Error())
+	} else if !sameFeatures(metadata.FeatureSet, training.Features) {
+		Println("Ignoring saved model for " + settings.Name + ", it was trained on different features")
+	} else if metadata.Normalizer.Method != training.Normalization {
+		Println("Ignoring saved model for " + settings.Name + ", it was trained with different normalization")
+	} else {
+		normalizer = metadata.Normalizer
+		Println("Loaded saved model for " + settings.Name + " from generation " + strconv.Itoa(metadata.Generation))
+		bestBot = net
+		bestFitness = metadata.Fitness
+		bestValidationFitness = metadata.ValidationFitness
+		generation = metadata.Generation
+		bots[0] = net
+	}
+	if err := recordLineage(settings.Name, bots, generation); err != nil {
+		println("Failed to record lineage, " + err.Error())
+	}
+	train(settings, sql, discord, startPoint, source)
+}
+
+// Continue a training run from its latest checkpoint
+func resumeTraining(checkpoint TrainingCheckpoint, sql *sql.DB, discord *discordgo.Session) {
+	settings := checkpoint.Settings
+	if err := validateTrainingSettings(checkpoint.Training); err != nil {
+		Println("Unable to resume, invalid training config in checkpoint: " + err.Error())
+		return
+	}
+	// Loaded configs derive the input size from the features, a checkpoint stores both
+	if pipeline, _ := newFeaturePipeline(checkpoint.Training.Features, checkpoint.Training.Lookback); checkpoint.Training.InputSize != pipeline.Width() {
+		Println("Unable to resume, checkpoint input size is " + strconv.Itoa(checkpoint.Training.InputSize) + " but its features produce " + strconv.Itoa(pipeline.Width()) + " inputs")
+		return
+	}
+	training = checkpoint.Training
+	population, source := restoreCheckpoint(checkpoint)
+	bots = population
+	BotLog(discord, settings.Name+" Bot Resuming on '"+settings.Market+"' at generation "+strconv.Itoa(generation))
+	Println(settings.Name + " Bot Resuming on '" + settings.Market + "' at generation " + strconv.Itoa(generation))
+	train(settings, sql, discord, checkpoint.StartPoint, source)
+}
+
+// Run generations until stopped, checkpointing along the way. With walk-forward enabled the cursor moves to the next window every few generations
+func train(settings BotSettings, sql *sql.DB, discord *discordgo.Session, cursor int64, source *rngSource) {
+	ctx, done := trackTraining(settings.Name)
+	defer done()
+	rng := rand.New(source)
+	pipeline := trainingPipeline(training)
+	pipeline.Normalizer = normalizer
+	fitness, err := getFitness(training, loadBacktestConfig(settings, pipeline))
+	if err != nil {
+		Println("Invalid fitness config, " + err.Error())
+		return
+	}
+	if _, err := getPositionSizer(settings); err != nil {
+		Println("Invalid bot settings, " + err.Error())
+		return
+	}
+	marketStart := getMarketStartingPoint(sql, settings.Market)
+	marketEnd := getMarketEndPoint(sql, settings.Market)
+	labeler, err := getLabeler(training)
+	if err != nil {
+		Println("Invalid labeler config, " + err.Error())
+		return
+	}
+	strategy, err := getWindowStrategy(training, sql, settings, pipeline, labeler, marketStart, marketEnd)
+	if err != nil {
+		Println("Invalid window config, " + err.Error())
+		return
+	}
+	validation := TrainingWindow{}
+	if training.WalkForward {
+		validation = loadValidationWindow(sql, settings, pipeline, labeler, cursor)
+		// Validation windows differ between runs, so rescore the best bot before comparing against it
+		if bestBot.HiddenLayers != nil {
+			bestValidationFitness = fitness.Fitness(newBatchNet(bestBot, training.Float32), validation)
+		}
+	}
 	for {
-		bots = runGeneration(discord, sql, startPoint, settings, bots)
+		state := source.State()
+		nextBots, err := runGeneration(ctx, rng, discord, settings, bots, strategy.Windows(rng, cursor), fitness, validation)
+		if err != nil {
+			// Save as if the generation never started, so resuming repeats it exactly
+			source.Restore(state)
+			if err := saveCheckpoint(settings, cursor, bots, source); err != nil {
+				println("Failed to save checkpoint, " + err.Error())
+			}
+			BotLog(discord, settings.Name+" Bot stopped training at generation "+strconv.Itoa(generation))
+			Println(settings.Name + " Bot stopped training at generation " + strconv.Itoa(generation))
+			return
+		}
+		bots = nextBots
 		generation++
+		next := strategy.Advance(cursor, generation)
+		if next != cursor {
+			// Refit on the history reachable from the new cursor, the checkpoint and newly saved models store it
+			normalizer = fitWindowNormalizer(sql, settings, strategy, next)
+			pipeline.Normalizer = normalizer
+			if strategy, err = getWindowStrategy(training, sql, settings, pipeline, labeler, marketStart, marketEnd); err != nil {
+				Println("Invalid window config, " + err.Error())
+				return
+			}
+		}
+		if training.WalkForward && next != cursor {
+			validation = loadValidationWindow(sql, settings, pipeline, labeler, next)
+			bestValidationFitness = fitness.Fitness(newBatchNet(bestBot, training.Float32), validation)
+			// Sequential windows move every generation, only report the move once per fold
+			if generation%training.WalkForwardGenerations == 0 {
+				info := "Walk-forward moved to " + time.Unix(next, 0).Format("2006-01-02 15:04") + Sprintf(", best bot validation: %.8f", bestValidationFitness)
+				BotLog(discord, info)
+				Println(info)
+			}
+		}
+		cursor = next
+		if generation%checkpointInterval == 0 {
+			if err := saveCheckpoint(settings, cursor, bots, source); err != nil {
+				println("Failed to save checkpoint, " + err.Error())
+			}
+			reportBestBot(settings, sql, discord, cursor)
+		}
 	}
 }
 
-func runGeneration(discord *discordgo.Session, sql *sql.DB, start int64, settings BotSettings, bots []NeuralNet) []NeuralNet {
+func runGeneration(ctx context.Context, rng *rand.Rand, discord *discordgo.Session, settings BotSettings, bots []NeuralNet, windows []TrainingWindow, fitness FitnessFunction, validation TrainingWindow) ([]NeuralNet, error) {
 	// Compute Bot Scoring
-	history := getHistory(sql, start, start+(60*60*60), settings.Market)
-	hourlyPoints := computePoints(sql, start, start+(60*60*60), settings)
-	botScores := make([]BotGenerationScore, 0)
-	botChannels := make([]chan BotGenerationScore, len(bots))
-	for x := 0; x < len(botChannels); x++ {
-		botChannels[x] = make(chan BotGenerationScore)
-	}
-	// Start Bot Calculations
-	for index, bot := range bots {
-		go runBotForGeneration(bot, history, hourlyPoints, botChannels[index])
-	}
-	// Collect bot calculations
-	for _, channel := range botChannels {
-		botScore := <-channel
-		botScores = append(botScores, botScore)
+	botScores, err := evaluatePopulation(ctx, workerCount(training), bots, windows, fitness, training.Float32)
+	if err != nil {
+		return nil, err
 	}
 	// Generation Scoring
 	bestOfGenerationScore := -1000000.0
@@ -62,53 +206,70 @@ func runGeneration(discord *discordgo.Session, sql *sql.DB, start int64, setting
 		}
 		generationalAvg = generationalAvg + botScore.score
 	}
-	// Check for best score
-	if bestOfGenerationScore > bestFitness || bestBot.HiddenLayers == nil {
+	// Check for best score, with walk-forward only out of sample improvements count
+	if training.WalkForward {
+		validationScore := fitness.Fitness(newBatchNet(bestGenerationBot, training.Float32), validation)
+		if validationScore > bestValidationFitness || bestBot.HiddenLayers == nil {
+			bestFitness = bestOfGenerationScore
+			bestValidationFitness = validationScore
+			bestBot = bestGenerationBot
+			saveBestBot(settings)
+		}
+	} else if bestOfGenerationScore > bestFitness || bestBot.HiddenLayers == nil {
 		bestFitness = bestOfGenerationScore
 		bestBot = bestGenerationBot
+		saveBestBot(settings)
 	}
-	generationalAvg = generationalAvg / botCount
+	generationalAvg = generationalAvg / float64(len(botScores))
 	// Display Info
 	generationInformational := Sprintf("Generation %s  Gen: %.8f Best: %.8f Avg %.8f \n", strconv.Itoa(generation), bestOfGenerationScore, bestFitness, generationalAvg)
+	if training.WalkForward {
+		generationInformational = Sprintf("Generation %s  Gen: %.8f Best: %.8f Avg %.8f Val: %.8f \n", strconv.Itoa(generation), bestOfGenerationScore, bestFitness, generationalAvg, bestValidationFitness)
+	}
 	BotLog(discord, generationInformational)
 	Printf(generationInformational)
 	// Setup Next Generation
-	topBots := getTop(botScores, botCount/10)
-	newBotsNeeded := botCount - len(topBots) - (botCount / 10)
+	topBots := getTop(botScores, eliteCount(training))
+	immigrants := immigrantCount(training)
+	newBotsNeeded := training.PopulationSize - len(topBots) - immigrants
 	bots = make([]NeuralNet, 0)
 	bots = append(bots, topBots...)
-	// Mutate to fill missing bots
+	// Breed and mutate to fill missing bots
+	selector, _ := getSelector(training)
+	crossover, _ := getCrossover(training.Crossover)
+	parents := selector.Select(rng, botScores, newBotsNeeded*2)
 	for x := 0; x < newBotsNeeded; x++ {
-		rand.Seed(time.Now().UnixNano())
-		randBot := rand.Intn(len(topBots))
-		rand.Seed(time.Now().UnixNano())
-		bots = append(bots, mutate(topBots[randBot], 10+rand.Intn(30)))
+		child := parents[x*2]
+		lineage := offspringLineage(rng, child)
+		if crossover != nil && rng.Float64() < training.CrossoverRate {
+			child = crossover(rng, child, parents[x*2+1])
+			lineage = offspringLineage(rng, parents[x*2], parents[x*2+1])
+			lineage.Mutations = append(lineage.Mutations, "crossover:"+training.Crossover)
+		}
+		child.Lineage = lineage
+		bots = append(bots, mutate(rng, child, training.MutationMin+rng.Intn(training.MutationMax-training.MutationMin)))
 	}
-	for x := 0; x < (botCount / 10); x++ {
-		bots = append(bots, RandomNet(14, 3, []int{12, 12, 12}, 13))
+	for x := 0; x < immigrants; x++ {
+		immigrant := randomBot(rng, training)
+		immigrant.Lineage = offspringLineage(rng)
+		bots = append(bots, immigrant)
 	}
-	return bots
+	if err := recordLineage(settings.Name, bots, generation+1); err != nil {
+		println("Failed to record lineage, " + err.Error())
+	}
+	return bots, nil
 }
 
-// Score a bot over the given history
-func scoreBot(net NeuralNet, history []HistoricalEntry, scoring []float64) float64 {
+// Score a bot over the inputs of a window
+func scoreBot(net BatchNet, inputs [][]float64, scoring []float64) float64 {
 	score := 0.0
-	for index, entry := range history {
-		netOutput := Compute(convertToNeural(entry), net) // 0 Nothing, 1 Buy, 2 Sell
+	for index, netOutput := range net.Forward(inputs) { // 0 Nothing, 1 Buy, 2 Sell
 		marketScore := scoring[index]
 		score = score + computeBotScore(netOutput, marketScore)
 	}
 	return score
 }
 
-func runBotForGeneration(net NeuralNet, history []HistoricalEntry, scoring []float64, channel chan BotGenerationScore) {
-	score := BotGenerationScore{
-		Bot:   net,
-		score: scoreBot(net, history, scoring),
-	}
-	channel <- score
-}
-
 // Calculate the score of the bots actions
 func computeBotScore(netOutput []float64, marketScore float64) float64 {
 	score := 0.0
@@ -126,22 +287,50 @@ func computeBotScore(netOutput []float64, marketScore float64) float64 {
 	return score
 }
 
-// Converts the history into something a neural net can understand, (0 - 1)
-func convertToNeural(entry HistoricalEntry) []float64 {
-	neueral := make([]float64, 13)
-	neueral[0] = sigmoid(entry.firstTradePrice / 10000)
-	neueral[1] = sigmoid(entry.lastTradePrice / 10000)
-	neueral[2] = sigmoid(entry.highestPrice / 10000)
-	neueral[3] = sigmoid(entry.lowestPrice / 10000)
-	neueral[4] = sigmoid(entry.volume / 10000)
-	return neueral
+// Backtest the current best bot on the training window and share the results
+func reportBestBot(settings BotSettings, sql *sql.DB, discord *discordgo.Session, startPoint int64) {
+	history := getHistory(sql, startPoint, startPoint+training.GenerationWindow, settings.Market)
+	pipeline := trainingPipeline(training)
+	pipeline.Normalizer = normalizer
+	result, err := Backtest(bestBot, history, loadBacktestConfig(settings, pipeline))
+	if err != nil {
+		println("Failed to backtest best bot, " + err.Error())
+		return
+	}
+	report := GenerateReport(settings.Name+" (Gen "+strconv.Itoa(generation)+")", settings.Market, result)
+	Print(report.Text())
+	BotLog(discord, report.Markdown())
+}
+
+// Write the current best bot to disk so it survives restarts
+func saveBestBot(settings BotSettings) {
+	err := SaveNet(modelPath(settings.Name), bestBot, ModelMetadata{
+		Name:              settings.Name,
+		Market:            settings.Market,
+		Generation:        generation,
+		Fitness:           bestFitness,
+		ValidationFitness: bestValidationFitness,
+		Seed:              trainingSeed,
+		FeatureSet:        training.Features,
+		Lookback:          training.Lookback,
+		Normalizer:        normalizer,
+	})
+	if err != nil {
+		println("Failed to save best bot, " + err.Error())
+	}
 }
 
 // Creates a fully new set of bots with random values
-func createRandomBots() []NeuralNet {
-	bots := make([]NeuralNet, botCount)
-	for index := 0; index < botCount; index++ {
-		bots[index] = RandomNet(14, 3, []int{12, 12, 12}, 13)
+func createRandomBots(rng *rand.Rand) []NeuralNet {
+	bots := make([]NeuralNet, training.PopulationSize)
+	for index := 0; index < training.PopulationSize; index++ {
+		bots[index] = randomBot(rng, training)
+		bots[index].Lineage = Lineage{
+			ID:         newGenomeID(rng),
+			ParentIDs:  make([]string, 0),
+			Generation: generation,
+			Mutations:  make([]string, 0),
+		}
 	}
 	return bots
 }
@@ -162,47 +351,11 @@ func getMarketStartingPoint(sql *sql.DB, market string) int64 {
 	return firstTimestamp
 }
 
-// Compute the best times to buy / sell based on a given set of start and end points / entries
-func computePoints(sql *sql.DB, startPoint int64, endPoint int64, settings BotSettings) []float64 {
-	increments := (endPoint - startPoint) / 60 // Amount of entries
-	history := getHistory(sql, startPoint, endPoint, settings.Market)
-	points := make([]float64, len(history))
-	// Find prices
-	diffIncrement := 1.0 / float64(increments)
-	currentHighestScore := 1.0
-	currentLowestScore := 0.0
-	for {
-		// Check for end of sorting
-		count := 0
-		for _, entry := range history {
-			if entry.lowestPrice == 0 && entry.highestPrice == 0 {
-				count++
-			}
-		}
-		if count == len(history) {
-			break
-		}
-		lowHigh := findHighAndLow(history)
-		// Add to points
-		points[lowHigh[0]] = currentLowestScore
-		points[lowHigh[1]] = currentHighestScore
-		// Set price to 0, ignored by lowHigh Sorting
-		history[lowHigh[0]].lowestPrice = 0
-		history[lowHigh[0]].highestPrice = 0
-		history[lowHigh[1]].lowestPrice = 0
-		history[lowHigh[1]].highestPrice = 0
-		// Update scores
-		currentHighestScore = currentHighestScore - diffIncrement
-		currentLowestScore = currentLowestScore + diffIncrement
-	}
-	return points
-}
-
 // Gets the historical data for the given time peroid
 func getHistory(sql *sql.DB, startPoint int64, endPoint int64, market string) []HistoricalEntry {
 	// SELECT * FROM market_data WHERE market='BTC-USD' AND timestamp BETWEEN '1437487200' AND '1437489000';
 	queryRows, err := sql.Query("SELECT * FROM market_data WHERE market='" + market + "' AND timestamp BETWEEN '" +
-		strconv.FormatInt(startPoint-1, 10) + "' AND '" + strconv.FormatInt(endPoint+1, 10) + "';")
+		strconv.FormatInt(startPoint-1, 10) + "' AND '" + strconv.FormatInt(endPoint+1, 10) + "' ORDER BY timestamp;")
 	if err != nil {
 		println(err.Error())
 		return make([]HistoricalEntry, 0)
@@ -220,95 +373,56 @@ func getHistory(sql *sql.DB, startPoint int64, endPoint int64, market string) []
 	return history
 }
 
-// Returns the index of the lowest and highest entries [low, high]
-func findHighAndLow(entries []HistoricalEntry) []int {
-	lowestIndex := 0
-	lowestPrice := entries[0].lowestPrice
-	highestIndex := 0
-	highestPrice := entries[0].highestPrice
-	for index, entry := range entries {
-		if entry.lowestPrice == 0 && entry.highestPrice == 0 { // Entry has been sorted
-			continue
-		}
-		// Check for new lowest price
-		if lowestPrice > entry.lowestPrice {
-			lowestIndex = index
-			lowestPrice = entry.lowestPrice
-		}
-		// Check for new highest price
-		if highestPrice < entry.highestPrice {
-			highestIndex = index
-			highestPrice = entry.highestPrice
-		}
+// Returns a mutated copy of the net, the original is left untouched
+func mutate(rng *rand.Rand, net NeuralNet, mutationCount int) NeuralNet {
+	child := net.Clone()
+	var gates [][]Neuron
+	if child.Recurrent != nil {
+		gates = child.Recurrent.gates()
 	}
-	lowHigh := make([]int, 2)
-	lowHigh[0] = lowestIndex
-	lowHigh[1] = highestIndex
-	return lowHigh
-}
-
-func mutate(net NeuralNet, mutationCount int) NeuralNet {
 	for x := 0; x < mutationCount; x++ {
-		rand.Seed(time.Now().UnixNano())
-		randLayer := rand.Intn(len(net.HiddenLayers))
-		randNeuron := 0
-		if randLayer > len(net.HiddenLayers)-1 {
-			rand.Seed(time.Now().UnixNano())
-			randNeuron = rand.Intn(len(net.OutputLayer))
-		} else {
-			randNeuron = rand.Intn(len(net.HiddenLayers))
-		}
-		if randLayer > len(net.HiddenLayers) { // Output Layer
-			net.OutputLayer[randNeuron] = mutateNeuron(net.OutputLayer[randNeuron])
-		} else { // Hidden Layers
-			net.HiddenLayers[randLayer][randNeuron] = mutateNeuron(net.HiddenLayers[randLayer][randNeuron])
+		randLayer := rng.Intn(len(child.HiddenLayers) + 1 + len(gates))
+		if randLayer > len(child.HiddenLayers) { // Recurrent gate
+			gate := randLayer - len(child.HiddenLayers) - 1
+			randNeuron := rng.Intn(len(gates[gate]))
+			var mutation string
+			gates[gate][randNeuron], mutation = mutateNeuron(rng, gates[gate][randNeuron])
+			child.Lineage.Mutations = append(child.Lineage.Mutations, Sprintf("R%dN%d%s", gate, randNeuron, mutation))
+			continue
 		}
+		neurons := getLayerNeurons(randLayer, child)
+		randNeuron := rng.Intn(len(neurons))
+		var mutation string
+		neurons[randNeuron], mutation = mutateNeuron(rng, neurons[randNeuron])
+		child.Lineage.Mutations = append(child.Lineage.Mutations, Sprintf("L%dN%d%s", randLayer, randNeuron, mutation))
 	}
-	return net
+	return child
 }
 
-func mutateNeuron(neuron Neuron) Neuron {
-	randSel := rand.Intn(3)
-	rand.Seed(time.Now().UnixNano())
-	addOrSub := rand.Intn(1)
-	rand.Seed(time.Now().UnixNano())
-	if randSel == 0 { // Activation
-		if addOrSub == 1 {
-			neuron.Activation += rand.Float64()
-		} else {
-			neuron.Activation -= rand.Float64()
-		}
-	} else if randSel == 1 { // Bias
-		if addOrSub == 1 {
-			neuron.Bias += rand.Float64()
-		} else {
-			neuron.Bias -= rand.Float64()
-		}
-	} else {
-		weight := rand.Intn(len(neuron.Weights))
-		if addOrSub == 1 {
-			neuron.Weights[weight] += rand.Float64() * 5
-		} else {
-			neuron.Weights[weight] -= rand.Float64() * 5
-		}
+// Nudge the bias or a single weight of a neuron, returning a short description of the change
+func mutateNeuron(rng *rand.Rand, neuron Neuron) (Neuron, string) {
+	randSel := rng.Intn(2)
+	change := rng.Float64()
+	if rng.Intn(2) == 0 {
+		change = -change
+	}
+	if randSel == 0 { // Bias
+		neuron.Bias += change
+		return neuron, Sprintf("B%+.4f", change)
 	}
-	return neuron
+	weight := rng.Intn(len(neuron.Weights))
+	neuron.Weights[weight] += change * 5
+	return neuron, Sprintf("W%d%+.4f", weight, change*5)
 }
 
+// Returns the best bots by score, the scores are left untouched
 func getTop(botScores []BotGenerationScore, count int) []NeuralNet {
 	topNets := make([]NeuralNet, 0)
-	for x := 0; x < count; x++ {
-		// Find best bot in current net array
-		bestIndex := 0
-		bestScore := -999999999.0
-		for index := 0; index < len(botScores); index++ {
-			if botScores[index].score > bestScore {
-				bestIndex = index
-				bestScore = botScores[index].score
-			}
+	for _, index := range rankedIndexes(botScores) {
+		if len(topNets) == count {
+			break
 		}
-		botScores[bestIndex].score = -1
-		topNets = append(topNets, botScores[bestIndex].Bot)
+		topNets = append(topNets, botScores[index].Bot)
 	}
 	return topNets
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Current version of the checkpoint format, bump when the layout changes
+// 1: Initial format, populations from before the forward pass used the hidden layers are rejected (see legacyForwardPass)
+// 2: Training settings stored alongside the population
+// 3: Run seed recorded
+// 4: Fitted input normalizer stored (version 3 checkpoints trained on unscaled inputs)
+// 5: Lookback and recurrent settings stored (older checkpoints use a lookback of 1)
+const checkpointVersion = 5
+
+// How many generations to run between checkpoints
+const checkpointInterval = 10
+
+// Amount of old checkpoints kept around per bot
+const checkpointsKept = 3
+
+type TrainingCheckpoint struct {
+	Version     int
+	Settings    BotSettings
+	Training    TrainingSettings
+	Seed        int64
+	Generation  int
+	BestFitness float64
+	BestBot     NeuralNet
+	Normalizer  Normalizer
+	Population  []NeuralNet
+	RandState   uint64
+	StartPoint  int64
+	SavedAt     int64
+}
+
+// Directory holding the checkpoints of a bot
+func checkpointDir(name string) string {
+	return filepath.Join(BaseDir, "checkpoints", name)
+}
+
+// Write the full training state to the bots checkpoint directory
+func saveCheckpoint(settings BotSettings, startPoint int64, population []NeuralNet, source *rngSource) error {
+	data, err := json.Marshal(TrainingCheckpoint{
+		Version:     checkpointVersion,
+		Settings:    settings,
+		Training:    training,
+		Seed:        trainingSeed,
+		Generation:  generation,
+		BestFitness: bestFitness,
+		BestBot:     bestBot,
+		Normalizer:  normalizer,
+		Population:  population,
+		RandState:   source.State(),
+		StartPoint:  startPoint,
+		SavedAt:     time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	dir := checkpointDir(settings.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("gen-%08d.json", generation))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	pruneCheckpoints(dir)
+	return nil
+}
+
+// List the checkpoint files in a directory, oldest first
+func listCheckpoints(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	checkpoints := make([]string, 0)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), "gen-") && strings.HasSuffix(file.Name(), ".json") {
+			checkpoints = append(checkpoints, filepath.Join(dir, file.Name()))
+		}
+	}
+	sort.Strings(checkpoints)
+	return checkpoints
+}
+
+// Remove all but the newest checkpoints
+func pruneCheckpoints(dir string) {
+	checkpoints := listCheckpoints(dir)
+	for index := 0; index < len(checkpoints)-checkpointsKept; index++ {
+		if err := os.Remove(checkpoints[index]); err != nil {
+			println(err.Error())
+		}
+	}
+}
+
+// Load the newest checkpoint of a bot, migrating checkpoints saved by older versions
+func loadLatestCheckpoint(name string) (TrainingCheckpoint, error) {
+	checkpoints := listCheckpoints(checkpointDir(name))
+	if len(checkpoints) == 0 {
+		return TrainingCheckpoint{}, errors.New("no checkpoints found for " + name)
+	}
+	path := checkpoints[len(checkpoints)-1]
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return TrainingCheckpoint{}, err
+	}
+	checkpoint, err := decodeCheckpoint(data)
+	if err != nil {
+		return TrainingCheckpoint{}, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return checkpoint, nil
+}
+
+// Decode a checkpoint of any supported version. Settings added since it was saved are filled with
+// the values the older version trained with, or the defaults where there were none.
+func decodeCheckpoint(data []byte) (TrainingCheckpoint, error) {
+	var raw struct {
+		Version  int
+		Training json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return TrainingCheckpoint{}, err
+	}
+	if raw.Version < 1 || raw.Version > checkpointVersion {
+		return TrainingCheckpoint{}, fmt.Errorf("checkpoint version %d is not supported, this build reads versions 1 to %d", raw.Version, checkpointVersion)
+	}
+	var checkpoint TrainingCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return TrainingCheckpoint{}, err
+	}
+	if len(checkpoint.Population) == 0 {
+		return TrainingCheckpoint{}, errors.New("checkpoint has an empty population")
+	}
+	if checkpoint.Version == 1 && legacyForwardPass(netTopology(checkpoint.Population[0])) {
+		return TrainingCheckpoint{}, errors.New("checkpoint was saved before the forward pass used the hidden layers (14 inputs, 13 outputs), its population cannot be resumed")
+	}
+	// Settings missing from the checkpoint keep what older versions used
+	checkpoint.Training = defaultTrainingSettings()
+	checkpoint.Training.Features = legacyFeatureSet
+	checkpoint.Training.Lookback = 1
+	checkpoint.Training.Normalization = "none"
+	checkpoint.Training.Recurrent = "none"
+	// Before activations were configurable every bot used the ones it was created with
+	checkpoint.Training.HiddenActivation = layerActivation(0, checkpoint.Population[0])
+	checkpoint.Training.OutputActivation = layerActivation(len(checkpoint.Population[0].HiddenLayers), checkpoint.Population[0])
+	weights := checkpoint.Training.FitnessWeights
+	checkpoint.Training.FitnessWeights = nil
+	if len(raw.Training) > 0 && string(raw.Training) != "null" {
+		if err := json.Unmarshal(raw.Training, &checkpoint.Training); err != nil {
+			return TrainingCheckpoint{}, err
+		}
+	}
+	if checkpoint.Training.FitnessWeights == nil {
+		checkpoint.Training.FitnessWeights = weights
+	}
+	if checkpoint.Version < 2 { // Topology was fixed in code, take it from the population
+		topology := netTopology(checkpoint.Population[0])
+		checkpoint.Training.InputSize = topology.InputSize
+		checkpoint.Training.HiddenLayers = topology.HiddenLayers
+		checkpoint.Training.OutputSize = topology.OutputSize
+	}
+	// Versions 1 and 2 have no seed, the random state is restored on its own so only the recorded seed is lost
+	if checkpoint.Version < 5 {
+		checkpoint.Training.Lookback = 1
+	}
+	for _, net := range checkpoint.Population {
+		if err := checkTopology(net, trainingTopology(checkpoint.Training)); err != nil {
+			return TrainingCheckpoint{}, err
+		}
+	}
+	if err := checkpoint.Normalizer.check(checkpoint.Training.InputSize / checkpoint.Training.Lookback); err != nil {
+		return TrainingCheckpoint{}, err
+	}
+	return checkpoint, nil
+}
+
+// Restore the training state from a checkpoint, returning the population and the random source to continue with
+func restoreCheckpoint(checkpoint TrainingCheckpoint) ([]NeuralNet, *rngSource) {
+	generation = checkpoint.Generation
+	bestFitness = checkpoint.BestFitness
+	bestBot = checkpoint.BestBot
+	normalizer = checkpoint.Normalizer
+	trainingSeed = checkpoint.Seed
+	source := newRngSource(checkpoint.Seed)
+	source.Restore(checkpoint.RandState)
+	return checkpoint.Population, source
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Version 1 checkpoint as written once the forward pass used the hidden layers, with the fixed 13 input,
+// 3x12 hidden and 3 output topology and no training settings, seed or normalizer
+func version1Checkpoint(t *testing.T, net NeuralNet) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"Version":    1,
+		"Settings":   BotSettings{Name: "old", Market: "BTC-USD"},
+		"Generation": 42,
+		"BestBot":    net,
+		"Population": []NeuralNet{net, net},
+		"RandState":  12345,
+		"StartPoint": 1600000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeVersion1Checkpoint(t *testing.T) {
+	legacy := RandomNet(rand.New(newRngSource(1)), 13, 3, []int{12, 12, 12}, 3)
+	tests := []struct {
+		name   string
+		net    NeuralNet
+		hidden Activation
+		output Activation
+	}{
+		{"before activations", legacy, ActivationSigmoid, ActivationSigmoid},
+		{"softmax output", withActivations(legacy, ActivationSigmoid, ActivationSoftmax), ActivationSigmoid, ActivationSoftmax},
+	}
+	for _, test := range tests {
+		checkpoint, err := decodeCheckpoint(version1Checkpoint(t, test.net))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		settings := checkpoint.Training
+		if !sameFeatures(settings.Features, legacyFeatureSet) || settings.Lookback != 1 || settings.Normalization != "none" {
+			t.Fatalf("%s: expected the legacy inputs, got %v lookback %d normalization %s", test.name, settings.Features, settings.Lookback, settings.Normalization)
+		}
+		if settings.InputSize != 13 || len(settings.HiddenLayers) != 3 || settings.HiddenLayers[2] != 12 || settings.OutputSize != 3 {
+			t.Fatalf("%s: topology not taken from the population, got %+v", test.name, trainingTopology(settings))
+		}
+		if settings.HiddenActivation != test.hidden || settings.OutputActivation != test.output {
+			t.Fatalf("%s: expected %s and %s activations, got %s and %s", test.name, test.hidden, test.output, settings.HiddenActivation, settings.OutputActivation)
+		}
+		if settings.PopulationSize != defaultTrainingSettings().PopulationSize || len(settings.FitnessWeights) == 0 {
+			t.Fatalf("%s: missing settings were not filled with the defaults", test.name)
+		}
+		// Resuming validates the settings before training
+		if err := validateTrainingSettings(settings); err != nil {
+			t.Fatalf("%s: migrated settings are invalid, %s", test.name, err.Error())
+		}
+		if checkpoint.Generation != 42 || checkpoint.RandState != 12345 || checkpoint.StartPoint != 1600000000 {
+			t.Fatalf("%s: training state lost, got %+v", test.name, checkpoint)
+		}
+		// Immigrants bred by the resumed run match the restored population
+		immigrant := randomBot(rand.New(newRngSource(2)), settings)
+		if checkTopology(immigrant, netTopology(test.net)) != nil || layerActivation(3, immigrant) != test.output {
+			t.Fatalf("%s: new bots do not match the restored population", test.name)
+		}
+	}
+}
+
+func TestDecodeCheckpointKeepsSavedSettings(t *testing.T) {
+	saved := defaultTrainingSettings()
+	saved.HiddenLayers = []int{6}
+	saved.FitnessWeights = map[string]float64{"pnl": 1}
+	saved.Normalization = "minmax"
+	data, err := json.Marshal(TrainingCheckpoint{Version: 4, Training: saved, Population: []NeuralNet{randomBot(rand.New(newRngSource(1)), saved)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := decodeCheckpoint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Training.Normalization != "minmax" || !sameFeatures(checkpoint.Training.Features, saved.Features) {
+		t.Fatalf("saved settings were replaced, got %+v", checkpoint.Training)
+	}
+	if len(checkpoint.Training.FitnessWeights) != 1 || checkpoint.Training.FitnessWeights["pnl"] != 1 {
+		t.Fatalf("saved weights were merged with the defaults, got %v", checkpoint.Training.FitnessWeights)
+	}
+}
+
+func TestDecodeUnsupportedCheckpoint(t *testing.T) {
+	for _, version := range []int{0, checkpointVersion + 1} {
+		data, _ := json.Marshal(TrainingCheckpoint{Version: version})
+		_, err := decodeCheckpoint(data)
+		if err == nil || !strings.Contains(err.Error(), "version "+strconv.Itoa(version)) {
+			t.Fatalf("expected the version %d to be named in the error, got %v", version, err)
+		}
+	}
+}
+
+func TestRejectCheckpointFromBeforeHiddenLayers(t *testing.T) {
+	net := RandomNet(rand.New(newRngSource(1)), 14, 3, []int{12, 12, 12}, 13)
+	data, err := json.Marshal(map[string]interface{}{"Version": 1, "Population": []NeuralNet{net}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeCheckpoint(data); err == nil || !strings.Contains(err.Error(), "hidden layers") {
+		t.Fatalf("expected the checkpoint to be rejected, got %v", err)
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"github.com/shopspring/decimal"
-	"os"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -16,6 +18,8 @@ func addCommands() {
 	commands["connect"] = connect
 	commands["exchange"] = exchange
 	commands["start"] = startupBot
+	commands["train"] = trainBot
+	commands["backtest"] = backtest
 }
 
 // Remove the provided amount of 's' from the begging of a string array
@@ -24,7 +28,7 @@ func remove(slice []string, s int) []string {
 }
 
 // Run a command
-func runCommands(command string, wg sync.WaitGroup) {
+func runCommands(command string, wg *sync.WaitGroup) {
 	command = strings.Replace(command, "\n", "", -1)
 	args := strings.Split(command, " ")
 	name := strings.ToLower(args[0])
@@ -47,65 +51,197 @@ func runCommands(command string, wg sync.WaitGroup) {
 func connect(args []string) {
 	if len(args) == 1 {
 		if strings.EqualFold(args[0], "list") {
-			fmt.Println("Supported Exchanges: [coinbase_pro]")
-		} else if strings.EqualFold(args[0], "coinbase_pro") {
-			setupCoinbaseToken()
+			fmt.Println("Supported Exchanges: [" + strings.Join(exchangeNames(), ", ") + "]")
+		} else if driver, err := getExchangeDriver(args[0]); err == nil {
+			driver.setup()
 		} else {
 			fmt.Println("Invalid Exchange!")
 		}
 	} else {
 		fmt.Println("connect <exchange>")
+		fmt.Println("connect list")
 	}
 }
 
 // Run the prefixed 'exchange' command
 func exchange(args []string) {
-	if len(args) == 2 {
-		if strings.EqualFold(args[0], "coinbase_pro") {
-			coinbase := connectToCoinbase()
-			if strings.EqualFold(args[1], "balance") {
-				accounts, err := coinbase.GetAccounts()
-				if err != nil {
-					fmt.Println("Failed to connect, Invalid Token's")
-				} else {
-					fmt.Println("Connected to CoinBase Pro!")
-					for _, a := range accounts {
-						bal, err := decimal.NewFromString(a.Balance)
-						if err != nil {
-							panic(err)
-						}
-						if bal.GreaterThan(decimal.NewFromInt(0)) {
-							fmt.Println("You have " + a.Balance + " " + a.Currency)
-						}
-					}
-				}
+	if len(args) == 2 && strings.EqualFold(args[1], "balance") {
+		exchange, err := openExchange(args[0])
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		balances, err := exchange.Balances()
+		if err != nil {
+			fmt.Println("Failed to connect, Invalid Token's")
+			return
+		}
+		fmt.Println("Connected to " + exchange.Name() + "!")
+		for _, balance := range balances {
+			if balance.Balance.GreaterThan(decimal.Zero) {
+				fmt.Println("You have " + balance.Balance.String() + " " + balance.Currency)
 			}
-		} else {
-			fmt.Println("Invalid Exchange!")
 		}
 	} else {
-		fmt.Println("exchange <exchange> test")
+		fmt.Println("exchange <exchange> balance")
 	}
 }
 
-// Startup the bot running on a single provided market
-// TODO Run multiple bots based on its name, via start <name>
+// Startup a bot running on a single provided market, on coinbase_pro unless another exchange is given.
+// With --paper it trades a simulated account, on the live prices of the exchange when one is given,
+// otherwise on the recorded market data.
 func startupBot(args []string) {
-	var encryptionDir = BaseDir + "/encryption/coinbase_pro.json"
-	_, err := os.Stat(encryptionDir)
-	if !(os.IsNotExist(err)) {
-		commandBot := make(chan string)
-		go startCoinbaseBot(commandBot, BotSettings{
-			Name:                  "Testing",
-			Market:                "BTC-USD",
-			UpdateTime:            300,
-			MarginSell:            0.01,
-			MarginBuy:             0.01,
-			AmountCalculationType: "SetCurrency",
-			AmountData:            "5",
-		})
+	paper := len(args) > 0 && strings.EqualFold(args[len(args)-1], "--paper")
+	if paper {
+		args = args[:len(args)-1]
+	}
+	if len(args) == 0 || len(args) > 2 || args[0] == "" {
+		fmt.Println("start <name> [exchange] [--paper]")
+		return
+	}
+	settings := defaultBotSettings(args[0])
+	var exchange Exchange
+	if len(args) == 2 || !paper {
+		name := "coinbase_pro"
+		if len(args) == 2 {
+			name = args[1]
+		}
+		opened, err := openExchange(name)
+		if err != nil {
+			fmt.Println("Unable to start, " + err.Error())
+			return
+		}
+		exchange = opened
+	}
+	sql := ConnectDB()
+	if paper {
+		account, err := newPaperExchange(settings.Name, settings.Market, sql, exchange)
+		if err != nil {
+			fmt.Println("Unable to start paper trading, " + err.Error())
+			return
+		}
+		exchange = account
+	}
+	commandBot := make(chan string)
+	go startBot(commandBot, exchange, settings, sql)
+}
+
+// Run the prefixed 'train' command
+func trainBot(args []string) {
+	if len(args) == 2 && strings.EqualFold(args[0], "resume") {
+		checkpoint, err := loadLatestCheckpoint(args[1])
+		if err != nil {
+			fmt.Println("Unable to resume training, " + err.Error())
+			return
+		}
+		go resumeTraining(checkpoint, ConnectDB(), StartupDiscordBot())
+	} else if len(args) == 2 && strings.EqualFold(args[0], "backprop") {
+		go runGradientTraining(defaultBotSettings(args[1]), ConnectDB(), StartupDiscordBot())
+	} else if len(args) == 2 && strings.EqualFold(args[0], "lineage") {
+		printLineage(args[1])
+	} else if len(args) == 2 && strings.EqualFold(args[0], "stop") {
+		if err := stopTraining(args[1]); err != nil {
+			fmt.Println("Unable to stop training, " + err.Error())
+		} else {
+			fmt.Println("Stopping training for " + args[1])
+		}
 	} else {
-		fmt.Println("You must first connect to coinbase pro!")
-		fmt.Println("connect coinbase_pro")
+		fmt.Println("train resume <name>")
+		fmt.Println("train backprop <name>")
+		fmt.Println("train lineage <name>")
+		fmt.Println("train stop <name>")
+	}
+}
+
+// Display the ancestry of a bots saved best model
+func printLineage(name string) {
+	net, _, err := LoadNet(modelPath(name))
+	if err != nil {
+		fmt.Println("Unable to load model, " + err.Error())
+		return
+	}
+	chain, err := traceLineage(name, net.Lineage.ID)
+	if err != nil {
+		fmt.Println("Unable to trace lineage, " + err.Error())
+		return
+	}
+	for _, lineage := range chain {
+		fmt.Printf("Gen %d %s Parents: %s Mutations: %d %s\n", lineage.Generation, lineage.ID, strings.Join(lineage.ParentIDs, ", "), len(lineage.Mutations), strings.Join(lineage.Mutations, " "))
+	}
+}
+
+// Run the prefixed 'backtest' command
+func backtest(args []string) {
+	postToDiscord := len(args) > 0 && strings.EqualFold(args[len(args)-1], "--discord")
+	if postToDiscord {
+		args = args[:len(args)-1]
+	}
+	if len(args) < 3 {
+		fmt.Println("backtest <model> db <start> <end> [--discord]")
+		fmt.Println("backtest <model> csv <file> [--discord]")
+		return
+	}
+	net, metadata, err := LoadNet(modelPath(args[0]))
+	if err != nil {
+		fmt.Println("Unable to load model, " + err.Error())
+		return
+	}
+	settings := defaultBotSettings(args[0])
+	if metadata.Market != "" {
+		settings.Market = metadata.Market
+	}
+	pipeline, err := newFeaturePipeline(metadata.FeatureSet, metadata.Lookback)
+	if err != nil {
+		fmt.Println("Unable to rebuild the features of the model, " + err.Error())
+		return
+	}
+	if pipeline.Width() != netTopology(net).InputSize {
+		fmt.Println("Model features do not match its input size")
+		return
+	}
+	pipeline.Normalizer = metadata.Normalizer
+	var history []HistoricalEntry
+	if strings.EqualFold(args[1], "db") && len(args) == 4 {
+		start, startErr := strconv.ParseInt(args[2], 10, 64)
+		end, endErr := strconv.ParseInt(args[3], 10, 64)
+		if startErr != nil || endErr != nil {
+			fmt.Println("Start and end must be unix timestamps")
+			return
+		}
+		history = getHistory(ConnectDB(), start, end, settings.Market)
+	} else if strings.EqualFold(args[1], "csv") && len(args) == 3 {
+		history, err = loadHistoryCSV(args[2], settings.Market)
+		if err != nil {
+			fmt.Println("Unable to read csv, " + err.Error())
+			return
+		}
+	} else {
+		fmt.Println("backtest <model> db <start> <end> [--discord]")
+		fmt.Println("backtest <model> csv <file> [--discord]")
+		return
+	}
+	result, err := Backtest(net, history, loadBacktestConfig(settings, pipeline))
+	if err != nil {
+		fmt.Println("Backtest failed, " + err.Error())
+		return
+	}
+	report := GenerateReport(args[0], settings.Market, result)
+	fmt.Print(report.Text())
+	tradeLog := filepath.Join(BaseDir, "backtests", args[0]+".csv")
+	if err := writeTradeLog(tradeLog, result.Trades); err != nil {
+		fmt.Println("Failed to write trade log, " + err.Error())
+	} else {
+		fmt.Println("Trade log written to " + tradeLog)
+	}
+	reportPath := filepath.Join(BaseDir, "backtests", args[0]+".json")
+	if data, err := report.JSON(); err != nil {
+		fmt.Println("Failed to create report, " + err.Error())
+	} else if err := ioutil.WriteFile(reportPath, data, 0644); err != nil {
+		fmt.Println("Failed to write report, " + err.Error())
+	} else {
+		fmt.Println("Report written to " + reportPath)
+	}
+	if postToDiscord {
+		BotLog(StartupDiscordBot(), report.Markdown())
 	}
 }
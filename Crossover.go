@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// Combines two parents into a new child, the parents are never modified
+type CrossoverFunc func(rng *rand.Rand, a NeuralNet, b NeuralNet) NeuralNet
+
+var crossoverStrategies = map[string]CrossoverFunc{
+	"uniform": uniformCrossover,
+	"neuron":  neuronCrossover,
+	"layer":   layerCrossover,
+	"blend":   blendCrossover,
+}
+
+// Find the configured crossover, "none" disables crossover
+func getCrossover(name string) (CrossoverFunc, error) {
+	if name == "none" {
+		return nil, nil
+	}
+	if crossover, ok := crossoverStrategies[name]; ok {
+		return crossover, nil
+	}
+	return nil, errors.New("unknown crossover '" + name + "'")
+}
+
+func copyNeuron(neuron Neuron) Neuron {
+	return Neuron{
+		Bias:    neuron.Bias,
+		Weights: append([]float64(nil), neuron.Weights...),
+	}
+}
+
+// Build a child by combining each layer of the parents, both parents must share a topology
+func combineLayers(a NeuralNet, b NeuralNet, combine func(a []Neuron, b []Neuron) []Neuron) NeuralNet {
+	child := NeuralNet{
+		HiddenLayers:      make([][]Neuron, len(a.HiddenLayers)),
+		OutputLayer:       combine(a.OutputLayer, b.OutputLayer),
+		HiddenActivations: append([]Activation(nil), a.HiddenActivations...),
+		OutputActivation:  a.OutputActivation,
+	}
+	for layer := range a.HiddenLayers {
+		child.HiddenLayers[layer] = combine(a.HiddenLayers[layer], b.HiddenLayers[layer])
+	}
+	if a.Recurrent != nil && b.Recurrent != nil {
+		child.Recurrent = &RecurrentLayer{Cell: a.Recurrent.Cell, Units: combine(a.Recurrent.Units, b.Recurrent.Units)}
+		if a.Recurrent.Cell == "gru" {
+			child.Recurrent.Update = combine(a.Recurrent.Update, b.Recurrent.Update)
+			child.Recurrent.Reset = combine(a.Recurrent.Reset, b.Recurrent.Reset)
+		}
+	}
+	return child
+}
+
+// Every weight and bias is taken from a random parent
+func uniformCrossover(rng *rand.Rand, a NeuralNet, b NeuralNet) NeuralNet {
+	return combineLayers(a, b, func(a []Neuron, b []Neuron) []Neuron {
+		layer := make([]Neuron, len(a))
+		for index := range a {
+			layer[index] = copyNeuron(a[index])
+			if rng.Intn(2) == 1 {
+				layer[index].Bias = b[index].Bias
+			}
+			for weight := range layer[index].Weights {
+				if rng.Intn(2) == 1 {
+					layer[index].Weights[weight] = b[index].Weights[weight]
+				}
+			}
+		}
+		return layer
+	})
+}
+
+// Every neuron is taken whole from a random parent
+func neuronCrossover(rng *rand.Rand, a NeuralNet, b NeuralNet) NeuralNet {
+	return combineLayers(a, b, func(a []Neuron, b []Neuron) []Neuron {
+		layer := make([]Neuron, len(a))
+		for index := range a {
+			if rng.Intn(2) == 1 {
+				layer[index] = copyNeuron(b[index])
+			} else {
+				layer[index] = copyNeuron(a[index])
+			}
+		}
+		return layer
+	})
+}
+
+// Every layer is taken whole from a random parent
+func layerCrossover(rng *rand.Rand, a NeuralNet, b NeuralNet) NeuralNet {
+	return combineLayers(a, b, func(a []Neuron, b []Neuron) []Neuron {
+		parent := a
+		if rng.Intn(2) == 1 {
+			parent = b
+		}
+		layer := make([]Neuron, len(parent))
+		for index := range parent {
+			layer[index] = copyNeuron(parent[index])
+		}
+		return layer
+	})
+}
+
+// Every weight and bias is a weighted average of both parents, using one random weighting per child
+func blendCrossover(rng *rand.Rand, a NeuralNet, b NeuralNet) NeuralNet {
+	alpha := rng.Float64()
+	return combineLayers(a, b, func(a []Neuron, b []Neuron) []Neuron {
+		layer := make([]Neuron, len(a))
+		for index := range a {
+			layer[index] = copyNeuron(a[index])
+			layer[index].Bias = alpha*a[index].Bias + (1-alpha)*b[index].Bias
+			for weight := range layer[index].Weights {
+				layer[index].Weights[weight] = alpha*a[index].Weights[weight] + (1-alpha)*b[index].Weights[weight]
+			}
+		}
+		return layer
+	})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	. "fmt"
+	"github.com/shopspring/decimal"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Market data, balances and order handling of a trading venue, the bot only talks to an exchange through this
+type Exchange interface {
+	// Name used in the 'connect' and 'exchange' commands and stored with the market data
+	Name() string
+	// Latest trade and best bid / ask of the market
+	Ticker(market string) (Ticker, error)
+	// 1 minute candles between start and end (unix seconds), sorted oldest first
+	Candles(market string, start int64, end int64) ([]HistoricalEntry, error)
+	Balances() ([]Balance, error)
+	// Open orders of every market
+	ActiveOrders() ([]Order, error)
+	// Place a limit order, returns it with the id given by the exchange
+	PlaceOrder(order Order) (Order, error)
+	CancelOrder(id string) error
+	// Fills of the market, newest first
+	Fills(market string) ([]Fill, error)
+	// Order size and price limits of the market
+	Product(market string) (Product, error)
+}
+
+type HistoricalEntry struct {
+	exchange        string
+	market          string
+	timestamp       int64
+	lowestPrice     float64
+	highestPrice    float64
+	firstTradePrice float64
+	lastTradePrice  float64
+	volume          float64
+}
+
+type Ticker struct {
+	Price decimal.Decimal
+	Bid   decimal.Decimal
+	Ask   decimal.Decimal
+	Time  int64
+}
+
+type Balance struct {
+	Currency  string
+	Balance   decimal.Decimal
+	Available decimal.Decimal
+	Hold      decimal.Decimal
+}
+
+type Order struct {
+	ID        string
+	Market    string
+	Side      string // buy or sell
+	Price     decimal.Decimal
+	Size      decimal.Decimal
+	Filled    decimal.Decimal
+	Status    string
+	CreatedAt int64
+}
+
+type Fill struct {
+	OrderID string
+	Market  string
+	Side    string
+	Price   decimal.Decimal
+	Size    decimal.Decimal
+	Fee     decimal.Decimal
+	Time    int64
+}
+
+type Product struct {
+	Market         string
+	BaseCurrency   string
+	QuoteCurrency  string
+	BaseMinSize    decimal.Decimal
+	BaseMaxSize    decimal.Decimal
+	QuoteIncrement decimal.Decimal
+}
+
+// How to setup and open an exchange by name
+type exchangeDriver struct {
+	setup      func()                   // Ask for and store the credentials, run by 'connect <exchange>'
+	configured func() bool              // Credentials have been stored
+	open       func() (Exchange, error) // Connect using the stored credentials
+}
+
+var exchangeDrivers = map[string]exchangeDriver{
+	"coinbase_pro": {
+		setup: setupCoinbaseToken,
+		configured: func() bool {
+			_, err := os.Stat(BaseDir + "/encryption/coinbase_pro.json")
+			return err == nil
+		},
+		open: newCoinbaseExchange,
+	},
+}
+
+// Names of every supported exchange, sorted
+func exchangeNames() []string {
+	names := make([]string, 0, len(exchangeDrivers))
+	for name := range exchangeDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Find the driver of an exchange by name
+func getExchangeDriver(name string) (exchangeDriver, error) {
+	driver, ok := exchangeDrivers[strings.ToLower(name)]
+	if !ok {
+		return exchangeDriver{}, errors.New("unknown exchange '" + name + "', supported exchanges: [" + strings.Join(exchangeNames(), ", ") + "]")
+	}
+	return driver, nil
+}
+
+// Connect to an exchange whose credentials have been stored
+func openExchange(name string) (Exchange, error) {
+	driver, err := getExchangeDriver(name)
+	if err != nil {
+		return nil, err
+	}
+	if !driver.configured() {
+		return nil, errors.New("not connected to " + name + ", run 'connect " + name + "' first")
+	}
+	return driver.open()
+}
+
+// Parse a decimal sent by an exchange, missing values are 0
+func parseDecimal(value string) decimal.Decimal {
+	number, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero
+	}
+	return number
+}
+
+func GetMidMarket(exchange Exchange, market string) decimal.Decimal {
+	ticker, err := exchange.Ticker(market)
+	if err != nil {
+		Println("Failed to get the ticker of " + market + ", " + err.Error())
+		return decimal.Zero
+	}
+	return decimal.Avg(ticker.Bid, ticker.Ask)
+}
+
+func GetActiveOrders(exchange Exchange) []Order {
+	orders, err := exchange.ActiveOrders()
+	if err != nil {
+		Println("Failed to get the active orders, " + err.Error())
+		return nil
+	}
+	return orders
+}
+
+func GetFills(exchange Exchange, market string) []Fill {
+	fills, err := exchange.Fills(market)
+	if err != nil {
+		Println("Failed to get the fills of " + market + ", " + err.Error())
+		return nil
+	}
+	return fills
+}
+
+func GetLastPurchase(exchange Exchange, market string, t string) Fill {
+	for _, fill := range GetFills(exchange, market) {
+		if strings.EqualFold(fill.Side, t) {
+			return fill
+		}
+	}
+	return Fill{}
+}
+
+func PlaceOrder(exchange Exchange, t string, market string, amount decimal.Decimal, price decimal.Decimal) {
+	for _, o := range GetActiveOrders(exchange) { // Check for current orders matching this one
+		if o.Market == market {
+			if strings.EqualFold(t, o.Side) {
+				if !(o.Price.Equals(price)) {
+					err := exchange.CancelOrder(o.ID)
+					if err != nil {
+						Println("Failed to cancel order! (" + o.ID + ")(" + o.Size.String() + " @ " + o.Price.String() + ")")
+						return
+					}
+					Println("Canceling order (" + o.Size.String() + " @ " + o.Price.String() + ")")
+				} else {
+					Println("Keeping Order (" + o.Size.String() + " @ " + o.Price.String() + ")")
+					return
+				}
+			}
+		}
+	}
+	order := Order{
+		Price:  price,
+		Size:   amount,
+		Side:   t,
+		Market: market,
+	}
+	_, err := exchange.PlaceOrder(order)
+	if err != nil {
+		Println("Failed to place order!")
+		Println(err)
+		return
+	} else {
+		Println("Placed " + t + " Order for " + market + " for " + amount.String() + " @ $" + price.String())
+	}
+}
+
+// Decimal places of the quote price and base size of a market
+func GetMarketDecimal(exchange Exchange, market string) [2]int {
+	product, err := exchange.Product(market)
+	if err != nil {
+		Println("Failed to get the product of " + market + ", " + err.Error())
+		return [2]int{0, 0}
+	}
+	return [2]int{decimalPlaces(product.QuoteIncrement), decimalPlaces(product.BaseMinSize)}
+}
+
+// Places needed to write an increment such as 0.01
+func decimalPlaces(increment decimal.Decimal) int {
+	for places := 0; places < 18; places++ {
+		if increment.Round(int32(places)).Equal(increment) {
+			return places
+		}
+	}
+	return 18
+}
+
+func GetTotalMoney(exchange Exchange, currencyType string) decimal.Decimal {
+	balances, err := exchange.Balances()
+	if err != nil {
+		Println("Failed to connect, Invalid Token's")
+		return decimal.Zero
+	}
+	for _, balance := range balances {
+		if strings.EqualFold(balance.Currency, currencyType) {
+			return balance.Balance
+		}
+	}
+	return decimal.Zero
+}
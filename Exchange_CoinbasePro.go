@@ -3,13 +3,14 @@ package main
 import (
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	. "fmt"
 	"github.com/bwmarrin/discordgo"
 	"github.com/preichenberger/go-coinbasepro/v2"
-	cb "github.com/preichenberger/go-coinbasepro/v2"
 	"github.com/shopspring/decimal"
 	"github.com/spf13/viper"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -21,17 +22,6 @@ type Coinbase_Auth struct {
 	apiToken   string
 }
 
-type HistoricalEntry struct {
-	exchange        string
-	market          string
-	timestamp       int64
-	lowestPrice     float64
-	highestPrice    float64
-	firstTradePrice float64
-	lastTradePrice  float64
-	volume          float64
-}
-
 var auth Coinbase_Auth
 var coinbaseConfig = setupConfig()
 var feePerc = decimal.NewFromFloat(.005)
@@ -42,6 +32,7 @@ func setupConfig() *viper.Viper {
 	coinbaseConfig.SetConfigType("json")
 	coinbaseConfig.AddConfigPath(BaseDir + "/encryption/")
 	coinbaseConfig.SetConfigFile(BaseDir + "/encryption/coinbase_pro.json")
+	coinbaseConfig.SetDefault("base_url", "https://api.pro.coinbase.com") // Point at the sandbox or a local server for testing
 	return coinbaseConfig
 }
 
@@ -86,144 +77,191 @@ func loadCoinbaseConfig() {
 
 func connectToCoinbase() *coinbasepro.Client {
 	loadCoinbaseConfig()
+	return newCoinbaseClient(coinbaseConfig.GetString("base_url"), auth.apiToken, auth.passphrase, auth.secretKey)
+}
+
+// Client for the api at baseURL signing its requests with the given credentials
+func newCoinbaseClient(baseURL string, key string, passphrase string, secret string) *coinbasepro.Client {
 	var coinbase = coinbasepro.NewClient()
 	coinbase.HTTPClient = &http.Client{
 		Timeout: 15 * time.Second,
 	}
 	coinbase.UpdateConfig(&coinbasepro.ClientConfig{
-		BaseURL:    "https://api.pro.coinbase.com",
-		Key:        auth.apiToken,
-		Passphrase: auth.passphrase,
-		Secret:     auth.secretKey,
+		BaseURL:    baseURL,
+		Key:        key,
+		Passphrase: passphrase,
+		Secret:     secret,
 	})
 	return coinbase
 }
 
-func startCoinbaseBot(command chan string, settings BotSettings) {
-	coinbase := connectToCoinbase()
-	sql := ConnectDB()
-	discord := StartupDiscordBot()
-	updateMarketHistory(coinbase, settings, sql, discord)
-	Println("Bot Initalization Complete")
-	go run(coinbase, settings, sql, discord)
+// Exchange adapter over the Coinbase Pro REST api
+type coinbaseExchange struct {
+	client *coinbasepro.Client
 }
 
-func GetMidMarket(market string, coinbase *coinbasepro.Client) decimal.Decimal {
-	ticker, _ := coinbase.GetTicker(market)
-	bidPrice, _ := decimal.NewFromString(ticker.Bid)
-	askPrice, _ := decimal.NewFromString(ticker.Ask)
-	return decimal.Avg(bidPrice, askPrice)
+func newCoinbaseExchange() (Exchange, error) {
+	return coinbaseExchange{client: connectToCoinbase()}, nil
 }
 
-func GetActiveOrders(coinbase *coinbasepro.Client) []coinbasepro.Order {
-	var orders []coinbasepro.Order
-	cursor := coinbase.ListOrders()
-	for cursor.HasMore {
-		if err := cursor.NextPage(&orders); err != nil {
-			return nil
-		}
+// Unix time of a timestamp sent by coinbase, 0 when it was missing
+func coinbaseTime(timestamp coinbasepro.Time) int64 {
+	if timestamp.Time().IsZero() {
+		return 0
 	}
-	if len(orders) == 0 {
-		if err := cursor.PrevPage(&orders); err != nil {
-			return nil
-		}
+	return timestamp.Time().Unix()
+}
+
+func (coinbase coinbaseExchange) Name() string { return "coinbase_pro" }
+
+func (coinbase coinbaseExchange) Ticker(market string) (Ticker, error) {
+	ticker, err := coinbase.client.GetTicker(market)
+	if err != nil {
+		return Ticker{}, err
 	}
-	return orders
+	return Ticker{
+		Price: parseDecimal(ticker.Price),
+		Bid:   parseDecimal(ticker.Bid),
+		Ask:   parseDecimal(ticker.Ask),
+		Time:  coinbaseTime(ticker.Time),
+	}, nil
 }
 
-func GetFills(coinbase *coinbasepro.Client, market string) []coinbasepro.Fill {
-	var fills []coinbasepro.Fill
-	cursor := coinbase.ListFills(coinbasepro.ListFillsParams{
-		OrderID:    "",
-		ProductID:  market,
-		Pagination: coinbasepro.PaginationParams{},
+func (coinbase coinbaseExchange) Candles(market string, start int64, end int64) ([]HistoricalEntry, error) {
+	rates, err := coinbase.client.GetHistoricRates(market, coinbasepro.GetHistoricRatesParams{
+		Start:       time.Unix(start, 0),
+		End:         time.Unix(end, 0),
+		Granularity: 60,
 	})
-	for cursor.HasMore {
-		if err := cursor.NextPage(&fills); err != nil {
-			return nil
-		}
+	if err != nil {
+		return nil, err
 	}
-	if len(fills) == 0 {
-		if err := cursor.PrevPage(&fills); err != nil {
-			return nil
+	candles := make([]HistoricalEntry, len(rates))
+	for index, rate := range rates {
+		candles[index] = HistoricalEntry{
+			exchange:        coinbase.Name(),
+			market:          market,
+			timestamp:       rate.Time.Unix(),
+			lowestPrice:     rate.Low,
+			highestPrice:    rate.High,
+			firstTradePrice: rate.Open,
+			lastTradePrice:  rate.Close,
+			volume:          rate.Volume,
 		}
 	}
-	return fills
+	// Coinbase sends the newest candle first
+	sort.Slice(candles, func(i, j int) bool { return candles[i].timestamp < candles[j].timestamp })
+	return candles, nil
 }
 
-func GetLastPurchase(coinbase *coinbasepro.Client, market string, t string) coinbasepro.Fill {
-	fills := GetFills(coinbase, market)
-	for _, fill := range fills {
-		if fill.Side == t {
-			return fill
+func (coinbase coinbaseExchange) Balances() ([]Balance, error) {
+	accounts, err := coinbase.client.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]Balance, len(accounts))
+	for index, account := range accounts {
+		balances[index] = Balance{
+			Currency:  account.Currency,
+			Balance:   parseDecimal(account.Balance),
+			Available: parseDecimal(account.Available),
+			Hold:      parseDecimal(account.Hold),
 		}
 	}
-	return coinbasepro.Fill{}
+	return balances, nil
 }
 
-func PlaceOrder(coinbase *coinbasepro.Client, t string, market string, amount decimal.Decimal, price decimal.Decimal) {
-	for _, o := range GetActiveOrders(coinbase) { // Check for current orders matching this one
-		if o.ProductID == market {
-			if strings.EqualFold(t, o.Side) {
-				orderPrice, _ := decimal.NewFromString(o.Price)
-				if !(orderPrice.Equals(price)) {
-					err := coinbase.CancelOrder(o.ID)
-					if err != nil {
-						Println("Failed to cancel order! (" + o.ID + ")(" + o.Size + " @ " + o.Price + ")")
-						return
-					}
-					Println("Canceling order (" + o.Size + " @ " + o.Price + ")")
-				} else {
-					Println("Keeping Order (" + o.Size + " @ " + o.Price + ")")
-					return
-				}
-			}
+func (coinbase coinbaseExchange) ActiveOrders() ([]Order, error) {
+	var orders []coinbasepro.Order
+	cursor := coinbase.client.ListOrders()
+	for cursor.HasMore {
+		var page []coinbasepro.Order
+		if err := cursor.NextPage(&page); err != nil {
+			return nil, err
 		}
+		orders = append(orders, page...)
 	}
-	order := coinbasepro.Order{
-		Price:     price.String(),
-		Size:      amount.String(),
-		Side:      t,
-		ProductID: market,
+	active := make([]Order, len(orders))
+	for index, order := range orders {
+		active[index] = coinbaseOrder(order)
 	}
-	_, err := coinbase.CreateOrder(&order)
+	return active, nil
+}
+
+func coinbaseOrder(order coinbasepro.Order) Order {
+	return Order{
+		ID:        order.ID,
+		Market:    order.ProductID,
+		Side:      order.Side,
+		Price:     parseDecimal(order.Price),
+		Size:      parseDecimal(order.Size),
+		Filled:    parseDecimal(order.FilledSize),
+		Status:    order.Status,
+		CreatedAt: coinbaseTime(order.CreatedAt),
+	}
+}
+
+func (coinbase coinbaseExchange) PlaceOrder(order Order) (Order, error) {
+	placed, err := coinbase.client.CreateOrder(&coinbasepro.Order{
+		Type:      "limit",
+		Price:     order.Price.String(),
+		Size:      order.Size.String(),
+		Side:      strings.ToLower(order.Side),
+		ProductID: order.Market,
+	})
 	if err != nil {
-		Println("Failed to place order!")
-		Println(err)
-		return
-	} else {
-		Println("Placed " + t + "Order for " + market + " for " + amount.String() + " @ $" + price.String())
+		return Order{}, err
 	}
+	return coinbaseOrder(placed), nil
 }
 
-func GetMarketDecimal(coinbase *coinbasepro.Client, market string) [2]int {
-	products, _ := coinbase.GetProducts()
-	for _, product := range products {
-		if strings.EqualFold(product.ID, market) {
-			return [2]int{strings.Index(product.QuoteIncrement, "1"), strings.Index(product.BaseMinSize, "1")}
+func (coinbase coinbaseExchange) CancelOrder(id string) error {
+	return coinbase.client.CancelOrder(id)
+}
+
+func (coinbase coinbaseExchange) Fills(market string) ([]Fill, error) {
+	var fills []coinbasepro.Fill
+	cursor := coinbase.client.ListFills(coinbasepro.ListFillsParams{ProductID: market})
+	for cursor.HasMore {
+		var page []coinbasepro.Fill
+		if err := cursor.NextPage(&page); err != nil {
+			return nil, err
+		}
+		fills = append(fills, page...)
+	}
+	converted := make([]Fill, len(fills))
+	for index, fill := range fills {
+		converted[index] = Fill{
+			OrderID: fill.FillID, // The library names the order id FillID
+			Market:  fill.ProductID,
+			Side:    fill.Side,
+			Price:   parseDecimal(fill.Price),
+			Size:    parseDecimal(fill.Size),
+			Fee:     parseDecimal(fill.Fee),
+			Time:    coinbaseTime(fill.CreatedAt),
 		}
 	}
-	return [2]int{0, 0}
+	return converted, nil
 }
 
-func GetTotalMoney(coinbase *coinbasepro.Client, currencyType string) decimal.Decimal {
-	accounts, err := coinbase.GetAccounts()
+func (coinbase coinbaseExchange) Product(market string) (Product, error) {
+	products, err := coinbase.client.GetProducts()
 	if err != nil {
-		Println("Failed to connect, Invalid Token's")
-	} else {
-		for _, a := range accounts {
-			bal, err := decimal.NewFromString(a.Balance)
-			if err != nil {
-				panic(err)
-			}
-			if bal.GreaterThan(decimal.NewFromInt(0)) {
-				if a.ID == currencyType {
-					return bal
-				}
-			}
+		return Product{}, err
+	}
+	for _, product := range products {
+		if strings.EqualFold(product.ID, market) {
+			return Product{
+				Market:         product.ID,
+				BaseCurrency:   product.BaseCurrency,
+				QuoteCurrency:  product.QuoteCurrency,
+				BaseMinSize:    parseDecimal(product.BaseMinSize),
+				BaseMaxSize:    parseDecimal(product.BaseMaxSize),
+				QuoteIncrement: parseDecimal(product.QuoteIncrement),
+			}, nil
 		}
 	}
-	return decimal.NewFromFloat(0)
+	return Product{}, errors.New("unknown market '" + market + "'")
 }
 
 type MarketData struct {
@@ -232,7 +270,7 @@ type MarketData struct {
 	sells  map[string]coinbasepro.Message
 }
 
-func updateMarketHistory(coinbase *coinbasepro.Client, settings BotSettings, sql *sql.DB, discord *discordgo.Session) {
+func updateMarketHistory(exchange Exchange, settings BotSettings, sql *sql.DB, discord *discordgo.Session) {
 	Println("Updating Market History")
 	startTimestmap := int64(1420088400) // Jan 1, 2015
 	// Get Latest timestamp and update from there
@@ -253,31 +291,26 @@ func updateMarketHistory(coinbase *coinbasepro.Client, settings BotSettings, sql
 		BotLog(discord, "Updating Market Data...")
 		BotLog(discord, "Currently "+strconv.Itoa(missingEntries)+" entries missing!")
 	}
-	updateMarketData(settings.Market, coinbase, startTimestmap, sql)
+	updateMarketData(exchange, settings.Market, startTimestmap, sql)
 }
 
-func updateMarketData(market string, coinbase *coinbasepro.Client, timestamp int64, sql *sql.DB) {
+func updateMarketData(exchange Exchange, market string, timestamp int64, sql *sql.DB) {
 	increment := int64(300 * 60) // 300 entires in 1m increments
 	for {
-		rates := cb.GetHistoricRatesParams{
-			Start:       time.Unix(timestamp, 0),
-			End:         time.Unix(timestamp+increment, 0),
-			Granularity: 60,
-		}
-		history, err := coinbase.GetHistoricRates(market, rates)
+		history, err := exchange.Candles(market, timestamp, timestamp+increment)
 		if err != nil {
 			println(err.Error())
 		}
 		for _, timeHistory := range history {
 			_, err := sql.Exec("INSERT INTO market_data (exchange, market, timestamp, lowest_price, highest_price, first_trade_price, last_trade_price, volume) VALUES " +
-				"('" + "coinbase_pro" + "', '" + market + "', '" + strconv.Itoa(int(timeHistory.Time.Unix())) + "', '" + strconv.FormatFloat(timeHistory.Low, 'g', 8, 64) + "', '" + strconv.FormatFloat(timeHistory.High, 'g', 8, 64) +
-				"', '" + strconv.FormatFloat(timeHistory.Open, 'g', 8, 64) + "', '" + strconv.FormatFloat(timeHistory.Close, 'g', 8, 64) + "', '" + strconv.FormatFloat(timeHistory.Volume, 'g', 8, 64) + "')")
+				"('" + exchange.Name() + "', '" + market + "', '" + strconv.FormatInt(timeHistory.timestamp, 10) + "', '" + strconv.FormatFloat(timeHistory.lowestPrice, 'g', 8, 64) + "', '" + strconv.FormatFloat(timeHistory.highestPrice, 'g', 8, 64) +
+				"', '" + strconv.FormatFloat(timeHistory.firstTradePrice, 'g', 8, 64) + "', '" + strconv.FormatFloat(timeHistory.lastTradePrice, 'g', 8, 64) + "', '" + strconv.FormatFloat(timeHistory.volume, 'g', 8, 64) + "')")
 			if err != nil {
 				println(err.Error())
 			}
 		}
 		if len(history) > 0 {
-			Println("Added " + strconv.Itoa(len(history)) + " Entries to DB, Currently at " + history[0].Time.Format("2006-01-02 15:04:05"))
+			Println("Added " + strconv.Itoa(len(history)) + " Entries to DB, Currently at " + time.Unix(history[len(history)-1].timestamp, 0).Format("2006-01-02 15:04:05"))
 		} else {
 			Println("Looking for when the history starts " + time.Unix(timestamp, 0).Format("2006-01-02 15:04:05"))
 		}
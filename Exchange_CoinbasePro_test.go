@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/preichenberger/go-coinbasepro/v2"
+	"github.com/shopspring/decimal"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Everything printed to stdout while running f
+func captureOutput(t *testing.T, f func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+	f()
+	writer.Close()
+	output, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+func TestUnsignedRequestRejected(t *testing.T) {
+	fake := newFakeCoinbase(t)
+	wrongSecret := coinbaseExchange{client: newCoinbaseClient(fake.server.URL, fakeKey, fakePassphrase, "d3Jvbmc=")}
+	if _, err := wrongSecret.Balances(); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("expected the signature to be rejected, got %v", err)
+	}
+	if len(fake.handled()) != 0 {
+		t.Fatal("rejected request was handled")
+	}
+}
+
+func TestPlaceOrder(t *testing.T) {
+	fake := newFakeCoinbase(t)
+	exchange := fake.exchange()
+	PlaceOrder(exchange, "buy", "BTC-USD", decimal.RequireFromString("0.01"), decimal.RequireFromString("19000"))
+	if len(fake.orders) != 1 {
+		t.Fatalf("expected 1 order, got %d", len(fake.orders))
+	}
+	order := fake.orders[0]
+	if order.Side != "buy" || order.ProductID != "BTC-USD" || order.Size != "0.01" || order.Price != "19000" || order.Type != "limit" {
+		t.Fatalf("unexpected order %+v", order)
+	}
+	// Same price keeps the order
+	PlaceOrder(exchange, "buy", "BTC-USD", decimal.RequireFromString("0.01"), decimal.RequireFromString("19000"))
+	if len(fake.orders) != 1 || fake.orders[0].Status != "open" {
+		t.Fatal("order at the same price was replaced")
+	}
+	// A new price replaces it
+	PlaceOrder(exchange, "buy", "BTC-USD", decimal.RequireFromString("0.02"), decimal.RequireFromString("19500"))
+	if len(fake.orders) != 2 || fake.orders[0].Status != "done" || fake.orders[1].Status != "open" || fake.orders[1].Price != "19500" {
+		t.Fatalf("expected the order to be replaced, got %+v", fake.orders)
+	}
+	// Orders on the other side are left alone
+	PlaceOrder(exchange, "sell", "BTC-USD", decimal.RequireFromString("0.01"), decimal.RequireFromString("21000"))
+	if len(fake.orders) != 3 || fake.orders[1].Status != "open" {
+		t.Fatalf("sell order touched the buy order, got %+v", fake.orders)
+	}
+}
+
+func TestGetActiveOrders(t *testing.T) {
+	fake := newFakeCoinbase(t)
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	fake.orders = []coinbasepro.Order{
+		{ID: "open-order", ProductID: "BTC-USD", Side: "sell", Price: "21000.50", Size: "0.5", FilledSize: "0.1", Status: "open", CreatedAt: coinbasepro.Time(created)},
+		{ID: "done-order", ProductID: "BTC-USD", Side: "buy", Price: "19000", Size: "1", Status: "done"},
+	}
+	orders := GetActiveOrders(fake.exchange())
+	if len(orders) != 1 {
+		t.Fatalf("expected 1 active order, got %d", len(orders))
+	}
+	order := orders[0]
+	if order.ID != "open-order" || order.Market != "BTC-USD" || order.Side != "sell" || order.Status != "open" {
+		t.Fatalf("unexpected order %+v", order)
+	}
+	if !order.Price.Equal(decimal.RequireFromString("21000.5")) || !order.Size.Equal(decimal.RequireFromString("0.5")) || !order.Filled.Equal(decimal.RequireFromString("0.1")) {
+		t.Fatalf("amounts not parsed, got %+v", order)
+	}
+	if order.CreatedAt != created.Unix() {
+		t.Fatalf("expected created at %d, got %d", created.Unix(), order.CreatedAt)
+	}
+}
+
+func TestUpdateMarketData(t *testing.T) {
+	fake := newFakeCoinbase(t)
+	start := time.Now().Truncate(time.Minute).Add(-time.Hour)
+	for minute := 0; minute < 3; minute++ {
+		fake.candles = append(fake.candles, coinbasepro.HistoricRate{
+			Time:   start.Add(time.Duration(minute) * time.Minute),
+			Low:    100 + float64(minute),
+			High:   110 + float64(minute),
+			Open:   105,
+			Close:  106 + float64(minute),
+			Volume: 2.5,
+		})
+	}
+	db, records := newFakeDatabase(t)
+	updateMarketData(fake.exchange(), "BTC-USD", start.Unix(), db)
+	inserts := records.executed()
+	if len(inserts) != 3 {
+		t.Fatalf("expected 3 inserts, got %d: %v", len(inserts), inserts)
+	}
+	// Stored oldest first
+	for minute, insert := range inserts {
+		timestamp := strconv.FormatInt(start.Add(time.Duration(minute)*time.Minute).Unix(), 10)
+		expected := "('coinbase_pro', 'BTC-USD', '" + timestamp + "', '" + strconv.Itoa(100+minute) + "', '" + strconv.Itoa(110+minute) + "', '105', '" + strconv.Itoa(106+minute) + "', '2.5')"
+		if !strings.HasPrefix(insert, "INSERT INTO market_data") || !strings.HasSuffix(insert, expected) {
+			t.Fatalf("unexpected insert %d: %s", minute, insert)
+		}
+	}
+}
+
+func TestBalanceCommand(t *testing.T) {
+	fake := newFakeCoinbase(t)
+	exchangeDrivers["fake_coinbase"] = exchangeDriver{
+		configured: func() bool { return true },
+		open:       func() (Exchange, error) { return fake.exchange(), nil },
+	}
+	defer delete(exchangeDrivers, "fake_coinbase")
+	output := captureOutput(t, func() { exchange([]string{"fake_coinbase", "balance"}) })
+	for _, line := range []string{"Connected to coinbase_pro!", "You have 1000.5 USD", "You have 0.25 BTC"} {
+		if !strings.Contains(output, line+"\n") {
+			t.Fatalf("expected %q in the output:\n%s", line, output)
+		}
+	}
+	if strings.Contains(output, "ETH") {
+		t.Fatalf("empty balance was printed:\n%s", output)
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	. "fmt"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var paperConfig viper.Viper
+
+// Tables holding the simulated accounts, one account per bot name
+const paperSchema = `
+CREATE TABLE IF NOT EXISTS paper_balances (account TEXT NOT NULL, currency TEXT NOT NULL, balance TEXT NOT NULL, hold TEXT NOT NULL, PRIMARY KEY (account, currency));
+CREATE TABLE IF NOT EXISTS paper_orders (account TEXT NOT NULL, id TEXT NOT NULL, market TEXT NOT NULL, side TEXT NOT NULL, price TEXT NOT NULL, size TEXT NOT NULL, status TEXT NOT NULL, created_at BIGINT NOT NULL, PRIMARY KEY (account, id));
+CREATE TABLE IF NOT EXISTS paper_fills (account TEXT NOT NULL, order_id TEXT NOT NULL, market TEXT NOT NULL, side TEXT NOT NULL, price TEXT NOT NULL, size TEXT NOT NULL, fee TEXT NOT NULL, timestamp BIGINT NOT NULL);
+CREATE TABLE IF NOT EXISTS paper_state (account TEXT NOT NULL PRIMARY KEY, clock BIGINT NOT NULL, processed BIGINT NOT NULL);
+`
+
+// Exchange that simulates an account, limit orders fill once the price feed crosses them. Prices are replayed
+// from the recorded market data, or taken from a live exchange when source is set.
+type paperExchange struct {
+	lock      sync.Mutex
+	account   string
+	sql       *sql.DB
+	source    Exchange // Live prices, nil to replay recorded candles
+	product   Product
+	balances  map[string]*Balance
+	orders    []Order // Open orders
+	fills     []Fill  // Newest first
+	clock     int64   // Simulated time when replaying
+	processed int64   // Timestamp of the last candle checked against the open orders
+	speed     int64   // Simulated seconds per real second
+	started   time.Time
+	startedAt int64 // Clock when the replay started
+	end       int64 // Last recorded candle, the replay stops there
+}
+
+func readPaperConfig() viper.Viper {
+	paperConfig := viper.New()
+	paperConfig.SetConfigName("paper")
+	paperConfig.SetConfigType("json")
+	paperConfig.AddConfigPath(BaseDir)
+	// Set Defaults
+	paperConfig.SetDefault("starting_quote", "1000")
+	paperConfig.SetDefault("starting_base", "0")
+	paperConfig.SetDefault("replay_start", 0)  // 0 starts at the first recorded candle
+	paperConfig.SetDefault("replay_speed", 60) // Simulated seconds per second, 60 replays a candle each second
+	paperConfig.SetDefault("base_min_size", "0.0001")
+	paperConfig.SetDefault("base_max_size", "10000")
+	paperConfig.SetDefault("quote_increment", "0.01")
+	// Read config
+	if err := paperConfig.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			paperConfig.SafeWriteConfig()
+		} else {
+			panic(err)
+		}
+	}
+	return *paperConfig
+}
+
+// Open the paper account of a bot, restoring its balances, orders and replay position from the database
+func newPaperExchange(account string, market string, sql *sql.DB, source Exchange) (*paperExchange, error) {
+	paperConfig = readPaperConfig()
+	currencies := strings.Split(market, "-")
+	if len(currencies) != 2 {
+		return nil, errors.New("market '" + market + "' is not in the BASE-QUOTE format")
+	}
+	product := Product{Market: market, BaseCurrency: currencies[0], QuoteCurrency: currencies[1]}
+	var err error
+	for _, limit := range []struct {
+		key   string
+		value *decimal.Decimal
+	}{{"base_min_size", &product.BaseMinSize}, {"base_max_size", &product.BaseMaxSize}, {"quote_increment", &product.QuoteIncrement}} {
+		if *limit.value, err = decimal.NewFromString(paperConfig.GetString(limit.key)); err != nil {
+			return nil, errors.New(limit.key + " must be a number")
+		}
+	}
+	paper := &paperExchange{
+		account:  account,
+		sql:      sql,
+		source:   source,
+		product:  product,
+		balances: make(map[string]*Balance),
+		speed:    paperConfig.GetInt64("replay_speed"),
+		started:  time.Now(),
+	}
+	if paper.speed < 1 {
+		return nil, errors.New("replay_speed must be at least 1")
+	}
+	if _, err := sql.Exec(paperSchema); err != nil {
+		return nil, err
+	}
+	if err := paper.load(); err != nil {
+		return nil, err
+	}
+	if len(paper.balances) == 0 { // New account
+		for currency, key := range map[string]string{product.QuoteCurrency: "starting_quote", product.BaseCurrency: "starting_base"} {
+			amount, err := decimal.NewFromString(paperConfig.GetString(key))
+			if err != nil || amount.IsNegative() {
+				return nil, errors.New(key + " must be a positive number")
+			}
+			paper.balances[currency] = &Balance{Currency: currency, Balance: amount}
+			if err := paper.saveBalance(currency); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if paper.clock == 0 {
+		paper.clock = paperConfig.GetInt64("replay_start")
+		if paper.clock == 0 {
+			paper.clock = getMarketStartingPoint(sql, market)
+		}
+		if source != nil {
+			paper.clock = time.Now().Unix()
+		}
+		paper.processed = paper.clock
+	}
+	paper.startedAt = paper.clock
+	paper.end = getMarketEndPoint(sql, market)
+	return paper, paper.saveState()
+}
+
+// Restore the account from the database
+func (paper *paperExchange) load() error {
+	rows, err := paper.sql.Query("SELECT currency, balance, hold FROM paper_balances WHERE account = $1", paper.account)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var currency, balance, hold string
+		if err := rows.Scan(&currency, &balance, &hold); err != nil {
+			rows.Close()
+			return err
+		}
+		paper.balances[currency] = &Balance{Currency: currency, Balance: parseDecimal(balance), Hold: parseDecimal(hold)}
+	}
+	rows.Close()
+	rows, err = paper.sql.Query("SELECT id, market, side, price, size, created_at FROM paper_orders WHERE account = $1 AND status = 'open' ORDER BY created_at", paper.account)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var order Order
+		var price, size string
+		if err := rows.Scan(&order.ID, &order.Market, &order.Side, &price, &size, &order.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		order.Price = parseDecimal(price)
+		order.Size = parseDecimal(size)
+		order.Status = "open"
+		paper.orders = append(paper.orders, order)
+	}
+	rows.Close()
+	rows, err = paper.sql.Query("SELECT order_id, market, side, price, size, fee, timestamp FROM paper_fills WHERE account = $1 ORDER BY timestamp DESC", paper.account)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var fill Fill
+		var price, size, fee string
+		if err := rows.Scan(&fill.OrderID, &fill.Market, &fill.Side, &price, &size, &fee, &fill.Time); err != nil {
+			rows.Close()
+			return err
+		}
+		fill.Price = parseDecimal(price)
+		fill.Size = parseDecimal(size)
+		fill.Fee = parseDecimal(fee)
+		paper.fills = append(paper.fills, fill)
+	}
+	rows.Close()
+	rows, err = paper.sql.Query("SELECT clock, processed FROM paper_state WHERE account = $1", paper.account)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return rows.Scan(&paper.clock, &paper.processed)
+	}
+	return nil
+}
+
+func (paper *paperExchange) saveBalance(currency string) error {
+	balance := paper.balances[currency]
+	_, err := paper.sql.Exec("INSERT INTO paper_balances (account, currency, balance, hold) VALUES ($1, $2, $3, $4) "+
+		"ON CONFLICT (account, currency) DO UPDATE SET balance = EXCLUDED.balance, hold = EXCLUDED.hold",
+		paper.account, currency, balance.Balance.String(), balance.Hold.String())
+	return err
+}
+
+func (paper *paperExchange) saveOrder(order Order) error {
+	_, err := paper.sql.Exec("INSERT INTO paper_orders (account, id, market, side, price, size, status, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) "+
+		"ON CONFLICT (account, id) DO UPDATE SET status = EXCLUDED.status",
+		paper.account, order.ID, order.Market, order.Side, order.Price.String(), order.Size.String(), order.Status, order.CreatedAt)
+	return err
+}
+
+func (paper *paperExchange) saveState() error {
+	_, err := paper.sql.Exec("INSERT INTO paper_state (account, clock, processed) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (account) DO UPDATE SET clock = EXCLUDED.clock, processed = EXCLUDED.processed",
+		paper.account, paper.clock, paper.processed)
+	return err
+}
+
+// Current time of the price feed
+func (paper *paperExchange) now() int64 {
+	if paper.source != nil {
+		return time.Now().Unix()
+	}
+	now := paper.startedAt + int64(time.Since(paper.started).Seconds())*paper.speed
+	if now > paper.end {
+		return paper.end
+	}
+	return now
+}
+
+// Candles of the price feed, never past the current time
+func (paper *paperExchange) feed(market string, start int64, end int64) ([]HistoricalEntry, error) {
+	if paper.source != nil {
+		return paper.source.Candles(market, start, end)
+	}
+	if end > paper.clock {
+		end = paper.clock
+	}
+	candles := make([]HistoricalEntry, 0)
+	if start > end {
+		return candles, nil
+	}
+	for _, candle := range getHistory(paper.sql, start, end, market) {
+		if candle.timestamp >= start && candle.timestamp <= end {
+			candles = append(candles, candle)
+		}
+	}
+	return candles, nil
+}
+
+// Move the clock forward and fill the open orders the candles since the last check crossed
+func (paper *paperExchange) advance() error {
+	if now := paper.now(); now > paper.clock {
+		paper.clock = now
+	}
+	if len(paper.orders) == 0 {
+		paper.processed = paper.clock
+		return paper.saveState()
+	}
+	start := paper.processed + 1
+	if paper.source != nil && paper.clock-start > 300*60 { // Most candles a live exchange sends at once
+		start = paper.clock - 300*60
+	}
+	candles, err := paper.feed(paper.product.Market, start, paper.clock)
+	if err != nil {
+		return err
+	}
+	for _, candle := range candles {
+		open := paper.orders[:0]
+		for _, order := range paper.orders {
+			crossed := order.Market == candle.market && ((order.Side == "buy" && decimal.NewFromFloat(candle.lowestPrice).LessThanOrEqual(order.Price)) ||
+				(order.Side == "sell" && decimal.NewFromFloat(candle.highestPrice).GreaterThanOrEqual(order.Price)))
+			if !crossed {
+				open = append(open, order)
+				continue
+			}
+			if err := paper.fill(order, candle.timestamp); err != nil {
+				return err
+			}
+		}
+		paper.orders = open
+		paper.processed = candle.timestamp
+	}
+	return paper.saveState()
+}
+
+// Settle an order at its limit price, charging feePerc of its value
+func (paper *paperExchange) fill(order Order, timestamp int64) error {
+	value := order.Price.Mul(order.Size)
+	fee := value.Mul(feePerc)
+	base := paper.balance(paper.product.BaseCurrency)
+	quote := paper.balance(paper.product.QuoteCurrency)
+	if order.Side == "buy" {
+		quote.Hold = quote.Hold.Sub(value.Add(fee))
+		quote.Balance = quote.Balance.Sub(value.Add(fee))
+		base.Balance = base.Balance.Add(order.Size)
+	} else {
+		base.Hold = base.Hold.Sub(order.Size)
+		base.Balance = base.Balance.Sub(order.Size)
+		quote.Balance = quote.Balance.Add(value.Sub(fee))
+	}
+	order.Status = "done"
+	order.Filled = order.Size
+	fill := Fill{OrderID: order.ID, Market: order.Market, Side: order.Side, Price: order.Price, Size: order.Size, Fee: fee, Time: timestamp}
+	paper.fills = append([]Fill{fill}, paper.fills...)
+	Println("Paper " + order.Side + " filled for " + order.Market + " " + order.Size.String() + " @ $" + order.Price.String())
+	if err := paper.saveOrder(order); err != nil {
+		return err
+	}
+	if _, err := paper.sql.Exec("INSERT INTO paper_fills (account, order_id, market, side, price, size, fee, timestamp) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		paper.account, fill.OrderID, fill.Market, fill.Side, fill.Price.String(), fill.Size.String(), fill.Fee.String(), fill.Time); err != nil {
+		return err
+	}
+	if err := paper.saveBalance(base.Currency); err != nil {
+		return err
+	}
+	return paper.saveBalance(quote.Currency)
+}
+
+// Balance of a currency, created empty when the account never held it
+func (paper *paperExchange) balance(currency string) *Balance {
+	if paper.balances[currency] == nil {
+		paper.balances[currency] = &Balance{Currency: currency}
+	}
+	return paper.balances[currency]
+}
+
+func (paper *paperExchange) Name() string { return "paper" }
+
+func (paper *paperExchange) Ticker(market string) (Ticker, error) {
+	paper.lock.Lock()
+	defer paper.lock.Unlock()
+	if err := paper.advance(); err != nil {
+		return Ticker{}, err
+	}
+	if paper.source != nil {
+		return paper.source.Ticker(market)
+	}
+	candles, err := paper.feed(market, paper.clock-3600, paper.clock)
+	if err != nil {
+		return Ticker{}, err
+	}
+	if len(candles) == 0 {
+		return Ticker{}, errors.New("no recorded candles for " + market + " before " + strconv.FormatInt(paper.clock, 10))
+	}
+	last := candles[len(candles)-1]
+	price := decimal.NewFromFloat(last.lastTradePrice)
+	return Ticker{Price: price, Bid: price, Ask: price, Time: last.timestamp}, nil
+}
+
+func (paper *paperExchange) Candles(market string, start int64, end int64) ([]HistoricalEntry, error) {
+	paper.lock.Lock()
+	defer paper.lock.Unlock()
+	if err := paper.advance(); err != nil {
+		return nil, err
+	}
+	return paper.feed(market, start, end)
+}
+
+func (paper *paperExchange) Balances() ([]Balance, error) {
+	paper.lock.Lock()
+	defer paper.lock.Unlock()
+	if err := paper.advance(); err != nil {
+		return nil, err
+	}
+	balances := make([]Balance, 0, len(paper.balances))
+	for _, balance := range paper.balances {
+		copied := *balance
+		copied.Available = balance.Balance.Sub(balance.Hold)
+		balances = append(balances, copied)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Currency < balances[j].Currency })
+	return balances, nil
+}
+
+func (paper *paperExchange) ActiveOrders() ([]Order, error) {
+	paper.lock.Lock()
+	defer paper.lock.Unlock()
+	if err := paper.advance(); err != nil {
+		return nil, err
+	}
+	return append([]Order(nil), paper.orders...), nil
+}
+
+// Place a limit order, holding the funds it needs until it fills or is cancelled
+func (paper *paperExchange) PlaceOrder(order Order) (Order, error) {
+	paper.lock.Lock()
+	defer paper.lock.Unlock()
+	if err := paper.advance(); err != nil {
+		return Order{}, err
+	}
+	order.Side = strings.ToLower(order.Side)
+	if !strings.EqualFold(order.Market, paper.product.Market) {
+		return Order{}, errors.New("paper account only trades " + paper.product.Market)
+	}
+	if !order.Price.IsPositive() || order.Size.LessThan(paper.product.BaseMinSize) || order.Size.GreaterThan(paper.product.BaseMaxSize) {
+		return Order{}, errors.New("order size must be between " + paper.product.BaseMinSize.String() + " and " + paper.product.BaseMaxSize.String() + " with a positive price")
+	}
+	var held *Balance
+	var amount decimal.Decimal
+	switch order.Side {
+	case "buy":
+		held = paper.balance(paper.product.QuoteCurrency)
+		amount = order.Price.Mul(order.Size).Mul(decimal.NewFromInt(1).Add(feePerc))
+	case "sell":
+		held = paper.balance(paper.product.BaseCurrency)
+		amount = order.Size
+	default:
+		return Order{}, errors.New("unknown order side '" + order.Side + "'")
+	}
+	if held.Balance.Sub(held.Hold).LessThan(amount) {
+		return Order{}, errors.New("insufficient funds, " + amount.String() + " " + held.Currency + " needed")
+	}
+	held.Hold = held.Hold.Add(amount)
+	order.ID = paper.account + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	order.Market = paper.product.Market
+	order.Status = "open"
+	order.Filled = decimal.Zero
+	order.CreatedAt = paper.clock
+	paper.orders = append(paper.orders, order)
+	if err := paper.saveOrder(order); err != nil {
+		return Order{}, err
+	}
+	return order, paper.saveBalance(held.Currency)
+}
+
+func (paper *paperExchange) CancelOrder(id string) error {
+	paper.lock.Lock()
+	defer paper.lock.Unlock()
+	if err := paper.advance(); err != nil {
+		return err
+	}
+	for index, order := range paper.orders {
+		if order.ID != id {
+			continue
+		}
+		held := paper.balance(paper.product.BaseCurrency)
+		amount := order.Size
+		if order.Side == "buy" {
+			held = paper.balance(paper.product.QuoteCurrency)
+			amount = order.Price.Mul(order.Size).Mul(decimal.NewFromInt(1).Add(feePerc))
+		}
+		held.Hold = held.Hold.Sub(amount)
+		paper.orders = append(paper.orders[:index], paper.orders[index+1:]...)
+		order.Status = "cancelled"
+		if err := paper.saveOrder(order); err != nil {
+			return err
+		}
+		return paper.saveBalance(held.Currency)
+	}
+	return errors.New("no open order " + id)
+}
+
+func (paper *paperExchange) Fills(market string) ([]Fill, error) {
+	paper.lock.Lock()
+	defer paper.lock.Unlock()
+	if err := paper.advance(); err != nil {
+		return nil, err
+	}
+	fills := make([]Fill, 0)
+	for _, fill := range paper.fills {
+		if strings.EqualFold(fill.Market, market) {
+			fills = append(fills, fill)
+		}
+	}
+	return fills, nil
+}
+
+func (paper *paperExchange) Product(market string) (Product, error) {
+	if !strings.EqualFold(market, paper.product.Market) {
+		return Product{}, errors.New("paper account only trades " + paper.product.Market)
+	}
+	return paper.product, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/shopspring/decimal"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Live price feed handing out each pushed candle once
+type fakePriceSource struct {
+	Exchange
+	candles []HistoricalEntry
+}
+
+func (source *fakePriceSource) Candles(market string, start int64, end int64) ([]HistoricalEntry, error) {
+	candles := source.candles
+	source.candles = nil
+	return candles, nil
+}
+
+// Paper account on BTC-USD with the given paper.json, priced by a fake live feed
+func newTestPaperExchange(t *testing.T, config string) (*paperExchange, *fakePriceSource, *fakeDatabase) {
+	baseDir := BaseDir
+	BaseDir = t.TempDir()
+	defer func() { BaseDir = baseDir }()
+	if err := ioutil.WriteFile(filepath.Join(BaseDir, "paper.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, fake := newFakeDatabase(t)
+	source := &fakePriceSource{}
+	paper, err := newPaperExchange("test", "BTC-USD", db, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paper, source, fake
+}
+
+// Balance and hold of a currency
+func checkPaperBalance(t *testing.T, paper *paperExchange, currency string, balance string, hold string) {
+	balances, err := paper.Balances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, found := range balances {
+		if found.Currency != currency {
+			continue
+		}
+		if !found.Balance.Equal(decimal.RequireFromString(balance)) || !found.Hold.Equal(decimal.RequireFromString(hold)) ||
+			!found.Available.Equal(found.Balance.Sub(found.Hold)) {
+			t.Fatalf("expected %s %s with %s held, got %s with %s held and %s available", currency, balance, hold, found.Balance, found.Hold, found.Available)
+		}
+		return
+	}
+	t.Fatalf("no %s balance", currency)
+}
+
+func paperOrder(side string, size string, price string) Order {
+	return Order{Market: "BTC-USD", Side: side, Size: decimal.RequireFromString(size), Price: decimal.RequireFromString(price)}
+}
+
+func TestPaperBuyHoldsFee(t *testing.T) {
+	paper, _, fake := newTestPaperExchange(t, `{"starting_quote": "1000"}`)
+	if _, err := paper.PlaceOrder(paperOrder("buy", "0.5", "1000")); err != nil {
+		t.Fatal(err)
+	}
+	// 500 for the coins and 2.5 for the fee
+	checkPaperBalance(t, paper, "USD", "1000", "502.5")
+	saved := false
+	for _, statement := range fake.executed() {
+		saved = saved || (strings.HasPrefix(statement, "INSERT INTO paper_balances") && strings.HasSuffix(statement, "test USD 1000 502.5"))
+	}
+	if !saved {
+		t.Fatal("hold was not saved")
+	}
+}
+
+func TestPaperInsufficientFunds(t *testing.T) {
+	paper, _, _ := newTestPaperExchange(t, `{"starting_quote": "1000"}`)
+	// 1000 covers the coins but not the fee
+	if _, err := paper.PlaceOrder(paperOrder("buy", "1", "1000")); err == nil || !strings.Contains(err.Error(), "insufficient funds, 1005 USD") {
+		t.Fatalf("expected the buy to be rejected, got %v", err)
+	}
+	if _, err := paper.PlaceOrder(paperOrder("buy", "0.5", "1000")); err != nil {
+		t.Fatal(err)
+	}
+	// Held funds are not available to the next order
+	if _, err := paper.PlaceOrder(paperOrder("buy", "0.5", "1000")); err == nil || !strings.Contains(err.Error(), "insufficient funds") {
+		t.Fatalf("expected held funds to be unavailable, got %v", err)
+	}
+	if _, err := paper.PlaceOrder(paperOrder("sell", "0.1", "1000")); err == nil || !strings.Contains(err.Error(), "insufficient funds, 0.1 BTC") {
+		t.Fatalf("expected the sell to be rejected, got %v", err)
+	}
+	checkPaperBalance(t, paper, "USD", "1000", "502.5")
+}
+
+func TestPaperFillsCrossedOrders(t *testing.T) {
+	paper, source, _ := newTestPaperExchange(t, `{"starting_quote": "1000", "starting_base": "1"}`)
+	for _, order := range []Order{paperOrder("buy", "0.5", "990"), paperOrder("buy", "0.2", "950"), paperOrder("sell", "0.4", "1010"), paperOrder("sell", "0.3", "1050")} {
+		if _, err := paper.PlaceOrder(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The low reaches the buy at 990 and the high the sell at 1010, the orders further out stay open
+	source.candles = []HistoricalEntry{{market: "BTC-USD", timestamp: paper.clock + 60, lowestPrice: 980, highestPrice: 1020, lastTradePrice: 1000}}
+	open, err := paper.ActiveOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 2 || !open[0].Price.Equal(decimal.NewFromInt(950)) || !open[1].Price.Equal(decimal.NewFromInt(1050)) {
+		t.Fatalf("expected the orders at 950 and 1050 to stay open, got %+v", open)
+	}
+	// Buy: 495 + 2.475 fee paid, 190.95 still held for the buy at 950
+	checkPaperBalance(t, paper, "USD", "904.505", "190.95")
+	// Sell: 404 - 2.02 fee received, 0.3 still held for the sell at 1050
+	checkPaperBalance(t, paper, "BTC", "1.1", "0.3")
+	fills, err := paper.Fills("BTC-USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 2 || fills[0].Side != "sell" || !fills[0].Fee.Equal(decimal.RequireFromString("2.02")) ||
+		fills[1].Side != "buy" || !fills[1].Fee.Equal(decimal.RequireFromString("2.475")) {
+		t.Fatalf("unexpected fills %+v", fills)
+	}
+}
+
+func TestPaperCancelReleasesHold(t *testing.T) {
+	paper, _, fake := newTestPaperExchange(t, `{"starting_quote": "1000"}`)
+	order, err := paper.PlaceOrder(paperOrder("buy", "0.5", "1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := paper.CancelOrder(order.ID); err != nil {
+		t.Fatal(err)
+	}
+	checkPaperBalance(t, paper, "USD", "1000", "0")
+	if open, _ := paper.ActiveOrders(); len(open) != 0 {
+		t.Fatalf("cancelled order is still open, %+v", open)
+	}
+	cancelled := false
+	for _, statement := range fake.executed() {
+		cancelled = cancelled || (strings.HasPrefix(statement, "INSERT INTO paper_orders") && strings.Contains(statement, order.ID) && strings.Contains(statement, "cancelled"))
+	}
+	if !cancelled {
+		t.Fatal("cancellation was not saved")
+	}
+	if err := paper.CancelOrder(order.ID); err == nil {
+		t.Fatal("expected cancelling twice to fail")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/preichenberger/go-coinbasepro/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeKey        = "test-key"
+	fakePassphrase = "test-passphrase"
+)
+
+var fakeSecret = base64.StdEncoding.EncodeToString([]byte("test-secret"))
+
+// In-process Coinbase Pro api, rejecting any request that is not signed with the fake credentials
+type fakeCoinbase struct {
+	lock     sync.Mutex
+	server   *httptest.Server
+	accounts []coinbasepro.Account
+	products []coinbasepro.Product
+	ticker   coinbasepro.Ticker
+	candles  []coinbasepro.HistoricRate
+	orders   []coinbasepro.Order
+	fills    []coinbasepro.Fill
+	requests []string // Method and path of every signed request
+	nextID   int
+}
+
+func newFakeCoinbase(t *testing.T) *fakeCoinbase {
+	fake := &fakeCoinbase{
+		accounts: []coinbasepro.Account{
+			{ID: "usd-account", Currency: "USD", Balance: "1000.50", Available: "900.50", Hold: "100"},
+			{ID: "btc-account", Currency: "BTC", Balance: "0.25", Available: "0.25", Hold: "0"},
+			{ID: "eth-account", Currency: "ETH", Balance: "0", Available: "0", Hold: "0"},
+		},
+		products: []coinbasepro.Product{
+			{ID: "BTC-USD", BaseCurrency: "BTC", QuoteCurrency: "USD", BaseMinSize: "0.0001", BaseMaxSize: "280", QuoteIncrement: "0.01"},
+		},
+		ticker: coinbasepro.Ticker{Price: "20000.00", Bid: "19999.00", Ask: "20001.00"},
+	}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// Exchange adapter talking to the fake server
+func (fake *fakeCoinbase) exchange() Exchange {
+	return coinbaseExchange{client: newCoinbaseClient(fake.server.URL, fakeKey, fakePassphrase, fakeSecret)}
+}
+
+// Requests handled so far
+func (fake *fakeCoinbase) handled() []string {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return append([]string(nil), fake.requests...)
+}
+
+func (fake *fakeCoinbase) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !fakeSigned(r, body) {
+		fakeError(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.requests = append(fake.requests, r.Method+" "+r.URL.Path)
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/accounts":
+		fakeJSON(w, fake.accounts)
+	case r.Method == "GET" && r.URL.Path == "/products":
+		fakeJSON(w, fake.products)
+	case r.Method == "GET" && len(path) == 3 && path[0] == "products" && path[2] == "ticker":
+		fakeJSON(w, fake.ticker)
+	case r.Method == "GET" && len(path) == 3 && path[0] == "products" && path[2] == "candles":
+		fake.serveCandles(w, r)
+	case r.Method == "GET" && r.URL.Path == "/orders":
+		open := make([]coinbasepro.Order, 0)
+		for _, order := range fake.orders {
+			if order.Status == "open" {
+				open = append(open, order)
+			}
+		}
+		fakeJSON(w, open)
+	case r.Method == "POST" && r.URL.Path == "/orders":
+		var order coinbasepro.Order
+		if err := json.Unmarshal(body, &order); err != nil {
+			fakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if order.Type != "limit" || order.ProductID == "" || order.Price == "" || order.Size == "" || (order.Side != "buy" && order.Side != "sell") {
+			fakeError(w, http.StatusBadRequest, "invalid order")
+			return
+		}
+		fake.nextID++
+		order.ID = "order-" + strconv.Itoa(fake.nextID)
+		order.Status = "open"
+		order.FilledSize = "0"
+		order.CreatedAt = coinbasepro.Time(time.Now().UTC().Truncate(time.Second))
+		fake.orders = append(fake.orders, order)
+		fakeJSON(w, order)
+	case r.Method == "DELETE" && len(path) == 2 && path[0] == "orders":
+		for index, order := range fake.orders {
+			if order.ID == path[1] && order.Status == "open" {
+				fake.orders[index].Status = "done"
+				fake.orders[index].DoneReason = "canceled"
+				fakeJSON(w, []string{order.ID})
+				return
+			}
+		}
+		fakeError(w, http.StatusNotFound, "order not found")
+	case r.Method == "GET" && r.URL.Path == "/fills":
+		fills := make([]coinbasepro.Fill, 0)
+		for _, fill := range fake.fills {
+			if fill.ProductID == r.URL.Query().Get("product_id") {
+				fills = append(fills, fill)
+			}
+		}
+		fakeJSON(w, fills)
+	default:
+		fakeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// Candles between the start and end parameters, as [time, low, high, open, close, volume] rows
+func (fake *fakeCoinbase) serveCandles(w http.ResponseWriter, r *http.Request) {
+	start, startErr := time.Parse(time.RFC3339, r.URL.Query().Get("start"))
+	end, endErr := time.Parse(time.RFC3339, r.URL.Query().Get("end"))
+	if startErr != nil || endErr != nil || r.URL.Query().Get("granularity") != "60" {
+		fakeError(w, http.StatusBadRequest, "invalid candle range")
+		return
+	}
+	rows := make([][6]float64, 0)
+	for _, candle := range fake.candles {
+		if !candle.Time.Before(start) && !candle.Time.After(end) {
+			rows = append(rows, [6]float64{float64(candle.Time.Unix()), candle.Low, candle.High, candle.Open, candle.Close, candle.Volume})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] > rows[j][0] }) // Newest first like the real api
+	fakeJSON(w, rows)
+}
+
+// Check the request carries the fake credentials and a valid signature of its timestamp, method, path and body
+func fakeSigned(r *http.Request, body []byte) bool {
+	if r.Header.Get("CB-ACCESS-KEY") != fakeKey || r.Header.Get("CB-ACCESS-PASSPHRASE") != fakePassphrase {
+		return false
+	}
+	timestamp := r.Header.Get("CB-ACCESS-TIMESTAMP")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > 30*time.Second {
+		return false
+	}
+	secret, _ := base64.StdEncoding.DecodeString(fakeSecret)
+	signature := hmac.New(sha256.New, secret)
+	signature.Write([]byte(timestamp + r.Method + r.URL.RequestURI() + string(body)))
+	expected := base64.StdEncoding.EncodeToString(signature.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("CB-ACCESS-SIGN")))
+}
+
+func fakeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func fakeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// database/sql driver recording the statements it is sent, queries return no rows
+type fakeDatabase struct {
+	lock  sync.Mutex
+	execs []string // Statements with their arguments appended
+}
+
+var fakeDatabases = struct {
+	lock      sync.Mutex
+	databases map[string]*fakeDatabase
+}{databases: make(map[string]*fakeDatabase)}
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+// Open an empty fake database
+func newFakeDatabase(t *testing.T) (*sql.DB, *fakeDatabase) {
+	fake := &fakeDatabase{}
+	fakeDatabases.lock.Lock()
+	name := t.Name() + "-" + strconv.Itoa(len(fakeDatabases.databases))
+	fakeDatabases.databases[name] = fake
+	fakeDatabases.lock.Unlock()
+	db, err := sql.Open("fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+// Statements executed so far
+func (fake *fakeDatabase) executed() []string {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return append([]string(nil), fake.execs...)
+}
+
+type fakeDriver struct{}
+
+type fakeConn struct {
+	db *fakeDatabase
+}
+
+type fakeStmt struct {
+	db    *fakeDatabase
+	query string
+}
+
+type fakeRows struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDatabases.lock.Lock()
+	defer fakeDatabases.lock.Unlock()
+	db, ok := fakeDatabases.databases[name]
+	if !ok {
+		return nil, errors.New("unknown fake database " + name)
+	}
+	return fakeConn{db: db}, nil
+}
+
+func (conn fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db: conn.db, query: query}, nil
+}
+
+func (fakeConn) Close() error { return nil }
+
+func (fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("transactions are not supported") }
+
+func (fakeStmt) Close() error { return nil }
+
+func (fakeStmt) NumInput() int { return -1 }
+
+func (stmt fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	stmt.db.lock.Lock()
+	defer stmt.db.lock.Unlock()
+	statement := stmt.query
+	for _, arg := range args {
+		statement += fmt.Sprintf(" %v", arg)
+	}
+	stmt.db.execs = append(stmt.db.execs, statement)
+	return driver.RowsAffected(1), nil
+}
+
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error) { return fakeRows{}, nil }
+
+func (fakeRows) Columns() []string { return []string{} }
+
+func (fakeRows) Close() error { return nil }
+
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Produces one or more inputs for every candle. Candles are sorted oldest first and the
+// values of a candle may only depend on it and the candles before it. Candles without
+// enough history get neutral values.
+type Feature interface {
+	Width() int
+	Compute(candles []HistoricalEntry, rows [][]float64, column int)
+}
+
+// Builds a feature from the numbers after its name, "sma_ratio:20" gives [20]
+type featureConstructor func(params []float64) (Feature, error)
+
+// Features that can be used in the 'features' config, along with their default parameters
+var featureConstructors = map[string]featureConstructor{
+	"returns":       periodFeature(func(period int) Feature { return returnsFeature{period: period, log: false} }, 1),
+	"log_returns":   periodFeature(func(period int) Feature { return returnsFeature{period: period, log: true} }, 1),
+	"sma_ratio":     periodFeature(func(period int) Feature { return smaRatioFeature{period: period} }, 20),
+	"ema_ratio":     periodFeature(func(period int) Feature { return emaRatioFeature{period: period} }, 20),
+	"rsi":           periodFeature(func(period int) Feature { return rsiFeature{period: period} }, 14),
+	"atr":           periodFeature(func(period int) Feature { return atrFeature{period: period} }, 14),
+	"vwap_distance": periodFeature(func(period int) Feature { return vwapFeature{period: period} }, 60),
+	"volume_zscore": periodFeature(func(period int) Feature { return volumeFeature{period: period} }, 60),
+	"macd":          newMACDFeature,
+	"bollinger_b":   newBollingerFeature,
+	"time_of_day":   cyclicFeature(24*60*60, 0),
+	"day_of_week":   cyclicFeature(7*24*60*60, 4*24*60*60), // The unix epoch was a Thursday
+	"legacy_ohlcv":  func(params []float64) (Feature, error) { return legacyFeature{}, nil },
+}
+
+// Features of bots trained before the feature pipeline existed
+var legacyFeatureSet = []string{"legacy_ohlcv"}
+
+// Ordered list of features turning candles into net inputs
+type FeaturePipeline struct {
+	Specs      []string
+	Lookback   int        // Candles stacked into each input, newest first
+	Normalizer Normalizer // Applied to the inputs by Transform, before stacking
+	features   []Feature
+	width      int
+}
+
+// Parse feature specs of the form name[:param[:param]], stacking the features of the last 'lookback' candles
+func newFeaturePipeline(specs []string, lookback int) (FeaturePipeline, error) {
+	if len(specs) == 0 {
+		return FeaturePipeline{}, errors.New("features must have at least one feature")
+	}
+	if lookback < 1 {
+		return FeaturePipeline{}, errors.New("lookback must be at least 1")
+	}
+	pipeline := FeaturePipeline{Specs: specs, Lookback: lookback}
+	for _, spec := range specs {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		constructor, ok := featureConstructors[parts[0]]
+		if !ok {
+			return FeaturePipeline{}, errors.New("unknown feature '" + parts[0] + "'")
+		}
+		params := make([]float64, len(parts)-1)
+		for index, part := range parts[1:] {
+			value, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return FeaturePipeline{}, errors.New("feature '" + spec + "' has an invalid parameter '" + part + "'")
+			}
+			params[index] = value
+		}
+		feature, err := constructor(params)
+		if err != nil {
+			return FeaturePipeline{}, fmt.Errorf("feature '%s': %s", spec, err.Error())
+		}
+		pipeline.features = append(pipeline.features, feature)
+		pipeline.width += feature.Width()
+	}
+	return pipeline, nil
+}
+
+// Amount of inputs produced for each candle
+func (pipeline FeaturePipeline) Width() int {
+	return pipeline.width * pipeline.Lookback
+}
+
+// Amount of features of a single candle
+func (pipeline FeaturePipeline) FeatureWidth() int {
+	return pipeline.width
+}
+
+// Normalized inputs for every candle, candles must be sorted oldest first
+func (pipeline FeaturePipeline) Transform(candles []HistoricalEntry) [][]float64 {
+	rows := pipeline.Raw(candles)
+	pipeline.Normalizer.Apply(rows)
+	if pipeline.Lookback <= 1 {
+		return rows
+	}
+	// Stack the rows of the previous candles after each row, candles before the start stay zero
+	stacked := make([][]float64, len(rows))
+	values := make([]float64, len(rows)*pipeline.Width())
+	for index := range stacked {
+		stacked[index] = values[index*pipeline.Width() : (index+1)*pipeline.Width()]
+		for back := 0; back < pipeline.Lookback && back <= index; back++ {
+			copy(stacked[index][back*pipeline.width:], rows[index-back])
+		}
+	}
+	return stacked
+}
+
+// Features of every candle before normalization and stacking
+func (pipeline FeaturePipeline) Raw(candles []HistoricalEntry) [][]float64 {
+	rows := make([][]float64, len(candles))
+	values := make([]float64, len(candles)*pipeline.width)
+	for index := range rows {
+		rows[index] = values[index*pipeline.width : (index+1)*pipeline.width]
+	}
+	column := 0
+	for _, feature := range pipeline.features {
+		feature.Compute(candles, rows, column)
+		column += feature.Width()
+	}
+	return rows
+}
+
+// Check two feature lists describe the same inputs
+func sameFeatures(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if strings.TrimSpace(a[index]) != strings.TrimSpace(b[index]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Constructor for features that only take a lookback period
+func periodFeature(create func(period int) Feature, defaultPeriod int) featureConstructor {
+	return func(params []float64) (Feature, error) {
+		period := defaultPeriod
+		if len(params) > 1 {
+			return nil, errors.New("expected at most 1 parameter")
+		}
+		if len(params) == 1 {
+			period = int(params[0])
+		}
+		if period < 1 {
+			return nil, errors.New("period must be at least 1")
+		}
+		return create(period), nil
+	}
+}
+
+// Constructor for a sin / cos encoding of the time within a repeating cycle
+func cyclicFeature(length int64, offset int64) featureConstructor {
+	return func(params []float64) (Feature, error) {
+		if len(params) > 0 {
+			return nil, errors.New("expected no parameters")
+		}
+		return cyclicTimeFeature{length: length, offset: offset}, nil
+	}
+}
+
+// Change in close over the period, optionally as a log return
+type returnsFeature struct {
+	period int
+	log    bool
+}
+
+func (feature returnsFeature) Width() int { return 1 }
+
+func (feature returnsFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	for index := feature.period; index < len(candles); index++ {
+		previous := candles[index-feature.period].lastTradePrice
+		if previous <= 0 || candles[index].lastTradePrice <= 0 {
+			continue
+		}
+		if feature.log {
+			rows[index][column] = math.Log(candles[index].lastTradePrice / previous)
+		} else {
+			rows[index][column] = candles[index].lastTradePrice/previous - 1
+		}
+	}
+}
+
+// Distance of the close from its simple moving average
+type smaRatioFeature struct {
+	period int
+}
+
+func (feature smaRatioFeature) Width() int { return 1 }
+
+func (feature smaRatioFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	sum := 0.0
+	for index, candle := range candles {
+		sum += candle.lastTradePrice
+		if index >= feature.period {
+			sum -= candles[index-feature.period].lastTradePrice
+		}
+		if index+1 >= feature.period && sum > 0 {
+			rows[index][column] = candle.lastTradePrice/(sum/float64(feature.period)) - 1
+		}
+	}
+}
+
+// Distance of the close from its exponential moving average
+type emaRatioFeature struct {
+	period int
+}
+
+func (feature emaRatioFeature) Width() int { return 1 }
+
+func (feature emaRatioFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	averages := ema(closes(candles), feature.period)
+	for index, candle := range candles {
+		if index+1 >= feature.period && averages[index] > 0 {
+			rows[index][column] = candle.lastTradePrice/averages[index] - 1
+		}
+	}
+}
+
+// Relative strength index scaled to 0 - 1, using Wilder's smoothing
+type rsiFeature struct {
+	period int
+}
+
+func (feature rsiFeature) Width() int { return 1 }
+
+func (feature rsiFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	gain := 0.0
+	loss := 0.0
+	for index, candle := range candles {
+		rows[index][column] = 0.5
+		if index == 0 {
+			continue
+		}
+		change := candle.lastTradePrice - candles[index-1].lastTradePrice
+		up := math.Max(change, 0)
+		down := math.Max(-change, 0)
+		if index <= feature.period {
+			gain += up / float64(feature.period)
+			loss += down / float64(feature.period)
+			if index < feature.period {
+				continue
+			}
+		} else {
+			gain = (gain*float64(feature.period-1) + up) / float64(feature.period)
+			loss = (loss*float64(feature.period-1) + down) / float64(feature.period)
+		}
+		if gain+loss > 0 {
+			rows[index][column] = gain / (gain + loss)
+		}
+	}
+}
+
+// MACD line and histogram, relative to the close
+type macdFeature struct {
+	fast   int
+	slow   int
+	signal int
+}
+
+func newMACDFeature(params []float64) (Feature, error) {
+	periods := []float64{12, 26, 9}
+	if len(params) != 0 && len(params) != 3 {
+		return nil, errors.New("expected no parameters or fast:slow:signal")
+	}
+	copy(periods, params)
+	feature := macdFeature{fast: int(periods[0]), slow: int(periods[1]), signal: int(periods[2])}
+	if feature.fast < 1 || feature.signal < 1 || feature.slow <= feature.fast {
+		return nil, errors.New("periods must be at least 1 with slow above fast")
+	}
+	return feature, nil
+}
+
+func (feature macdFeature) Width() int { return 2 }
+
+func (feature macdFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	prices := closes(candles)
+	fast := ema(prices, feature.fast)
+	slow := ema(prices, feature.slow)
+	line := make([]float64, len(candles))
+	for index := range line {
+		line[index] = fast[index] - slow[index]
+	}
+	signal := ema(line, feature.signal)
+	for index, price := range prices {
+		if index+1 < feature.slow || price <= 0 {
+			continue
+		}
+		rows[index][column] = line[index] / price
+		rows[index][column+1] = (line[index] - signal[index]) / price
+	}
+}
+
+// Position of the close within the Bollinger bands, 0 at the lower band and 1 at the upper
+type bollingerFeature struct {
+	period     int
+	deviations float64
+}
+
+func newBollingerFeature(params []float64) (Feature, error) {
+	values := []float64{20, 2}
+	if len(params) > 2 {
+		return nil, errors.New("expected at most period:deviations")
+	}
+	copy(values, params)
+	if values[0] < 2 || values[1] <= 0 {
+		return nil, errors.New("period must be at least 2 and deviations above 0")
+	}
+	return bollingerFeature{period: int(values[0]), deviations: values[1]}, nil
+}
+
+func (feature bollingerFeature) Width() int { return 1 }
+
+func (feature bollingerFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	prices := closes(candles)
+	for index := range candles {
+		rows[index][column] = 0.5
+		if index+1 < feature.period {
+			continue
+		}
+		mean, deviation := meanDeviation(prices[index+1-feature.period : index+1])
+		if deviation > 0 {
+			lower := mean - feature.deviations*deviation
+			rows[index][column] = (prices[index] - lower) / (2 * feature.deviations * deviation)
+		}
+	}
+}
+
+// Average true range relative to the close, using Wilder's smoothing
+type atrFeature struct {
+	period int
+}
+
+func (feature atrFeature) Width() int { return 1 }
+
+func (feature atrFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	average := 0.0
+	for index, candle := range candles {
+		trueRange := candle.highestPrice - candle.lowestPrice
+		if index > 0 {
+			previous := candles[index-1].lastTradePrice
+			trueRange = math.Max(trueRange, math.Max(math.Abs(candle.highestPrice-previous), math.Abs(candle.lowestPrice-previous)))
+		}
+		if index < feature.period {
+			average += trueRange / float64(feature.period)
+		} else {
+			average = (average*float64(feature.period-1) + trueRange) / float64(feature.period)
+		}
+		if index+1 >= feature.period && candle.lastTradePrice > 0 {
+			rows[index][column] = average / candle.lastTradePrice
+		}
+	}
+}
+
+// Distance of the close from the rolling volume weighted average price
+type vwapFeature struct {
+	period int
+}
+
+func (feature vwapFeature) Width() int { return 1 }
+
+func (feature vwapFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	traded := 0.0
+	volume := 0.0
+	typical := func(candle HistoricalEntry) float64 {
+		return (candle.highestPrice + candle.lowestPrice + candle.lastTradePrice) / 3
+	}
+	for index, candle := range candles {
+		traded += typical(candle) * candle.volume
+		volume += candle.volume
+		if index >= feature.period {
+			old := candles[index-feature.period]
+			traded -= typical(old) * old.volume
+			volume -= old.volume
+		}
+		if volume > 0 && traded > 0 {
+			rows[index][column] = candle.lastTradePrice/(traded/volume) - 1
+		}
+	}
+}
+
+// How unusual the volume of a candle is compared to the period before it
+type volumeFeature struct {
+	period int
+}
+
+func (feature volumeFeature) Width() int { return 1 }
+
+func (feature volumeFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	volumes := make([]float64, len(candles))
+	for index, candle := range candles {
+		volumes[index] = candle.volume
+	}
+	for index := range candles {
+		if index+1 < feature.period || feature.period < 2 {
+			continue
+		}
+		mean, deviation := meanDeviation(volumes[index+1-feature.period : index+1])
+		if deviation > 0 {
+			rows[index][column] = (volumes[index] - mean) / deviation
+		}
+	}
+}
+
+// Sin / cos of the position within a cycle, so the end of a cycle sits next to its start
+type cyclicTimeFeature struct {
+	length int64
+	offset int64
+}
+
+func (feature cyclicTimeFeature) Width() int { return 2 }
+
+func (feature cyclicTimeFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	for index, candle := range candles {
+		position := float64((candle.timestamp+feature.offset)%feature.length) / float64(feature.length)
+		rows[index][column] = math.Sin(2 * math.Pi * position)
+		rows[index][column+1] = math.Cos(2 * math.Pi * position)
+	}
+}
+
+// The original inputs, sigmoid squashed prices and volume padded to 13 inputs
+type legacyFeature struct{}
+
+func (legacyFeature) Width() int { return 13 }
+
+func (legacyFeature) Compute(candles []HistoricalEntry, rows [][]float64, column int) {
+	for index, candle := range candles {
+		rows[index][column] = sigmoid(candle.firstTradePrice / 10000)
+		rows[index][column+1] = sigmoid(candle.lastTradePrice / 10000)
+		rows[index][column+2] = sigmoid(candle.highestPrice / 10000)
+		rows[index][column+3] = sigmoid(candle.lowestPrice / 10000)
+		rows[index][column+4] = sigmoid(candle.volume / 10000)
+	}
+}
+
+func closes(candles []HistoricalEntry) []float64 {
+	prices := make([]float64, len(candles))
+	for index, candle := range candles {
+		prices[index] = candle.lastTradePrice
+	}
+	return prices
+}
+
+// Exponential moving average, seeded with the first value
+func ema(values []float64, period int) []float64 {
+	averages := make([]float64, len(values))
+	alpha := 2 / float64(period+1)
+	for index, value := range values {
+		if index == 0 {
+			averages[index] = value
+			continue
+		}
+		averages[index] = alpha*value + (1-alpha)*averages[index-1]
+	}
+	return averages
+}
+
+// Mean and population standard deviation
+func meanDeviation(values []float64) (float64, float64) {
+	mean := 0.0
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// Data a generation is scored on
+type TrainingWindow struct {
+	History []HistoricalEntry // Sorted oldest first
+	Inputs  [][]float64       // Features of each entry
+	Labels  []float64         // Market score of each entry from the labeler
+}
+
+// Scores a bot on a window, higher is better
+type FitnessFunction interface {
+	Fitness(net BatchNet, window TrainingWindow) float64
+}
+
+// Average fitness over several windows
+func averageFitness(fitness FitnessFunction, net BatchNet, windows []TrainingWindow) float64 {
+	if len(windows) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, window := range windows {
+		total += fitness.Fitness(net, window)
+	}
+	return total / float64(len(windows))
+}
+
+// Agreement with the buy / sell labels (the original scoring)
+type labelFitness struct{}
+
+// Return of a simulated portfolio
+type pnlFitness struct {
+	config BacktestConfig
+}
+
+// Annualized Sharpe ratio of a simulated portfolio
+type sharpeFitness struct {
+	config BacktestConfig
+}
+
+// Return of a simulated portfolio, minus its max drawdown scaled by penalty
+type drawdownFitness struct {
+	config  BacktestConfig
+	penalty float64
+}
+
+// Weighted sum of other fitness functions
+type weightedFitness struct {
+	names     []string
+	functions []FitnessFunction
+	weights   []float64
+}
+
+// Create the configured fitness function
+func getFitness(settings TrainingSettings, config BacktestConfig) (FitnessFunction, error) {
+	if settings.Fitness == "weighted" {
+		if len(settings.FitnessWeights) == 0 {
+			return nil, errors.New("fitness_weights must be set for weighted fitness")
+		}
+		names := make([]string, 0, len(settings.FitnessWeights))
+		for name := range settings.FitnessWeights {
+			names = append(names, name)
+		}
+		sort.Strings(names) // Keep the evaluation order stable
+		weighted := weightedFitness{names: names}
+		for _, name := range names {
+			function, err := getSingleFitness(name, settings, config)
+			if err != nil {
+				return nil, err
+			}
+			weighted.functions = append(weighted.functions, function)
+			weighted.weights = append(weighted.weights, settings.FitnessWeights[name])
+		}
+		return weighted, nil
+	}
+	return getSingleFitness(settings.Fitness, settings, config)
+}
+
+func getSingleFitness(name string, settings TrainingSettings, config BacktestConfig) (FitnessFunction, error) {
+	switch name {
+	case "label":
+		return labelFitness{}, nil
+	case "pnl":
+		return pnlFitness{config: config}, nil
+	case "sharpe":
+		return sharpeFitness{config: config}, nil
+	case "pnl_drawdown":
+		if settings.DrawdownPenalty < 0 {
+			return nil, errors.New("fitness_drawdown_penalty must be at least 0")
+		}
+		return drawdownFitness{config: config, penalty: settings.DrawdownPenalty}, nil
+	}
+	return nil, errors.New("unknown fitness '" + name + "'")
+}
+
+func (fitness labelFitness) Fitness(net BatchNet, window TrainingWindow) float64 {
+	return scoreBot(net, window.Inputs, window.Labels)
+}
+
+// A bot that cannot be backtested is scored as losing everything
+func backtestReturn(net BatchNet, window TrainingWindow, config BacktestConfig) (BacktestResult, float64) {
+	result, err := backtestInputs(net, window.History, window.Inputs, config)
+	if err != nil || result.StartingEquity <= 0 {
+		return result, -1
+	}
+	return result, result.FinalEquity/result.StartingEquity - 1
+}
+
+func (fitness pnlFitness) Fitness(net BatchNet, window TrainingWindow) float64 {
+	_, profit := backtestReturn(net, window, fitness.config)
+	return profit
+}
+
+func (fitness sharpeFitness) Fitness(net BatchNet, window TrainingWindow) float64 {
+	result, err := backtestInputs(net, window.History, window.Inputs, fitness.config)
+	if err != nil {
+		return -1
+	}
+	sharpe, _ := riskAdjustedReturns(result)
+	return sharpe
+}
+
+func (fitness drawdownFitness) Fitness(net BatchNet, window TrainingWindow) float64 {
+	result, profit := backtestReturn(net, window, fitness.config)
+	if len(result.Equity) == 0 {
+		return profit
+	}
+	drawdown, _ := maxDrawdown(result)
+	return profit - fitness.penalty*drawdown
+}
+
+func (fitness weightedFitness) Fitness(net BatchNet, window TrainingWindow) float64 {
+	total := 0.0
+	for index, function := range fitness.functions {
+		total += fitness.weights[index] * function.Fitness(net, window)
+	}
+	return total
+}
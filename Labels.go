@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Windows kept in the label cache before the oldest is dropped
+const labelCacheSize = 256
+
+// Scores every candle of a window for training, 0 is a time to buy, 1 a time to sell. computeBotScore
+// treats scores below .2 as buy, above .8 as sell and anything between as waiting.
+type Labeler interface {
+	// Identifies the labeler and its parameters
+	Name() string
+	// Scores of candles sorted oldest first
+	Label(history []HistoricalEntry) []float64
+}
+
+// The original scoring, the lowest lows rank towards 0 and the highest highs towards 1
+type rankLabeler struct{}
+
+// Buy when the close 'horizon' candles ahead is up by threshold, sell when it is down by threshold
+type forwardReturnLabeler struct {
+	horizon   int
+	threshold float64
+}
+
+// Buy when the take-profit barrier is hit before the stop-loss, sell when the stop-loss is hit first, wait on time-out
+type tripleBarrierLabeler struct {
+	horizon    int
+	takeProfit float64
+	stopLoss   float64
+}
+
+// Position of each close between the zig-zag low and high of its swing, swings must move by threshold
+type zigZagLabeler struct {
+	threshold float64
+}
+
+// Create the configured labeler
+func getLabeler(settings TrainingSettings) (Labeler, error) {
+	switch settings.Labeler {
+	case "rank", "": // Checkpoints from before labelers existed
+		return rankLabeler{}, nil
+	case "forward_return":
+		if settings.LabelHorizon < 1 || settings.LabelThreshold <= 0 {
+			return nil, errors.New("label_horizon must be at least 1 and label_threshold above 0")
+		}
+		return forwardReturnLabeler{horizon: settings.LabelHorizon, threshold: settings.LabelThreshold}, nil
+	case "triple_barrier":
+		if settings.LabelHorizon < 1 || settings.LabelTakeProfit <= 0 || settings.LabelStopLoss <= 0 {
+			return nil, errors.New("label_horizon must be at least 1, label_take_profit and label_stop_loss above 0")
+		}
+		return tripleBarrierLabeler{horizon: settings.LabelHorizon, takeProfit: settings.LabelTakeProfit, stopLoss: settings.LabelStopLoss}, nil
+	case "zigzag":
+		if settings.LabelThreshold <= 0 {
+			return nil, errors.New("label_threshold must be above 0")
+		}
+		return zigZagLabeler{threshold: settings.LabelThreshold}, nil
+	}
+	return nil, errors.New("unknown labeler '" + settings.Labeler + "'")
+}
+
+func (rankLabeler) Name() string { return "rank" }
+
+// Each step pairs the lowest remaining low with the highest remaining high, moving both scores towards the middle
+func (rankLabeler) Label(history []HistoricalEntry) []float64 {
+	labels := make([]float64, len(history))
+	done := make([]bool, len(history))
+	lows := make([]int, 0, len(history))
+	for index, entry := range history {
+		if entry.lowestPrice == 0 && entry.highestPrice == 0 { // No trades, left at 0
+			done[index] = true
+			continue
+		}
+		lows = append(lows, index)
+	}
+	highs := append([]int(nil), lows...)
+	sort.SliceStable(lows, func(i, j int) bool { return history[lows[i]].lowestPrice < history[lows[j]].lowestPrice })
+	sort.SliceStable(highs, func(i, j int) bool { return history[highs[i]].highestPrice > history[highs[j]].highestPrice })
+	step := 1.0 / float64(len(history))
+	lowScore := 0.0
+	highScore := 1.0
+	low := 0
+	high := 0
+	remaining := len(lows)
+	for remaining > 0 {
+		for done[lows[low]] {
+			low++
+		}
+		for done[highs[high]] {
+			high++
+		}
+		labels[lows[low]] = lowScore
+		labels[highs[high]] = highScore
+		done[lows[low]] = true
+		remaining--
+		if !done[highs[high]] {
+			done[highs[high]] = true
+			remaining--
+		}
+		lowScore += step
+		highScore -= step
+	}
+	return labels
+}
+
+func (labeler forwardReturnLabeler) Name() string {
+	return fmt.Sprintf("forward_return:%d:%g", labeler.horizon, labeler.threshold)
+}
+
+func (labeler forwardReturnLabeler) Label(history []HistoricalEntry) []float64 {
+	labels := make([]float64, len(history))
+	for index, entry := range history {
+		labels[index] = 0.5
+		if index+labeler.horizon >= len(history) || entry.lastTradePrice <= 0 {
+			continue
+		}
+		change := history[index+labeler.horizon].lastTradePrice/entry.lastTradePrice - 1
+		if change >= labeler.threshold {
+			labels[index] = 0
+		} else if change <= -labeler.threshold {
+			labels[index] = 1
+		}
+	}
+	return labels
+}
+
+func (labeler tripleBarrierLabeler) Name() string {
+	return fmt.Sprintf("triple_barrier:%d:%g:%g", labeler.horizon, labeler.takeProfit, labeler.stopLoss)
+}
+
+func (labeler tripleBarrierLabeler) Label(history []HistoricalEntry) []float64 {
+	labels := make([]float64, len(history))
+	for index, entry := range history {
+		labels[index] = 0.5
+		profit := entry.lastTradePrice * (1 + labeler.takeProfit)
+		loss := entry.lastTradePrice * (1 - labeler.stopLoss)
+		for ahead := index + 1; ahead < len(history) && ahead <= index+labeler.horizon; ahead++ {
+			// When both barriers fall in one candle the order is unknown, so assume the loss
+			if history[ahead].lowestPrice <= loss {
+				labels[index] = 1
+				break
+			}
+			if history[ahead].highestPrice >= profit {
+				labels[index] = 0
+				break
+			}
+		}
+	}
+	return labels
+}
+
+func (labeler zigZagLabeler) Name() string {
+	return fmt.Sprintf("zigzag:%g", labeler.threshold)
+}
+
+func (labeler zigZagLabeler) Label(history []HistoricalEntry) []float64 {
+	labels := make([]float64, len(history))
+	if len(history) == 0 {
+		return labels
+	}
+	// Find the turning points, a swing only counts once the price moved back by threshold
+	pivots := []int{0}
+	extreme := 0
+	direction := 0 // 1 rising, -1 falling, 0 unknown
+	for index := 1; index < len(history); index++ {
+		price := history[index].lastTradePrice
+		extremePrice := history[extreme].lastTradePrice
+		switch {
+		case direction >= 0 && price > extremePrice, direction <= 0 && price < extremePrice:
+			if direction == 0 {
+				direction = 1
+				if price < extremePrice {
+					direction = -1
+				}
+			}
+			extreme = index
+		case direction > 0 && price <= extremePrice*(1-labeler.threshold), direction < 0 && price >= extremePrice*(1+labeler.threshold):
+			pivots = append(pivots, extreme)
+			extreme = index
+			direction = -direction
+		}
+	}
+	if extreme != pivots[len(pivots)-1] {
+		pivots = append(pivots, extreme)
+	}
+	if pivots[len(pivots)-1] != len(history)-1 {
+		pivots = append(pivots, len(history)-1)
+	}
+	// Scale each close between the low and high of its swing
+	for pivot := 1; pivot < len(pivots); pivot++ {
+		from := pivots[pivot-1]
+		to := pivots[pivot]
+		low := history[from].lastTradePrice
+		high := history[to].lastTradePrice
+		if low > high {
+			low, high = high, low
+		}
+		for index := from; index <= to; index++ {
+			labels[index] = 0.5
+			if high > low {
+				labels[index] = (history[index].lastTradePrice - low) / (high - low)
+			}
+		}
+	}
+	return labels
+}
+
+// Labels of recently used windows
+type labelCache struct {
+	lock   sync.Mutex
+	labels map[string][]float64
+	order  []string
+}
+
+var windowLabels = labelCache{labels: make(map[string][]float64)}
+
+// Labels of a window, computed once per labeler, market, bounds and amount of candles
+func cachedLabels(labeler Labeler, market string, start int64, end int64, history []HistoricalEntry) []float64 {
+	key := fmt.Sprintf("%s|%s|%d|%d|%d", labeler.Name(), market, start, end, len(history))
+	windowLabels.lock.Lock()
+	labels, ok := windowLabels.labels[key]
+	windowLabels.lock.Unlock()
+	if ok {
+		return labels
+	}
+	labels = labeler.Label(history)
+	windowLabels.lock.Lock()
+	defer windowLabels.lock.Unlock()
+	if _, ok := windowLabels.labels[key]; !ok {
+		if len(windowLabels.order) >= labelCacheSize {
+			delete(windowLabels.labels, windowLabels.order[0])
+			windowLabels.order = windowLabels.order[1:]
+		}
+		windowLabels.labels[key] = labels
+		windowLabels.order = append(windowLabels.order, key)
+	}
+	return labels
+}
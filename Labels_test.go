@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// The scoring computePoints used before labelers, ported to work on a loaded window. The step is
+// taken from the amount of candles like rankLabeler does.
+func legacyPoints(entries []HistoricalEntry) []float64 {
+	history := append([]HistoricalEntry(nil), entries...)
+	points := make([]float64, len(history))
+	diffIncrement := 1.0 / float64(len(history))
+	currentHighestScore := 1.0
+	currentLowestScore := 0.0
+	for {
+		count := 0
+		for _, entry := range history {
+			if entry.lowestPrice == 0 && entry.highestPrice == 0 {
+				count++
+			}
+		}
+		if count == len(history) {
+			break
+		}
+		lowestIndex := 0
+		lowestPrice := history[0].lowestPrice
+		highestIndex := 0
+		highestPrice := history[0].highestPrice
+		for index, entry := range history {
+			if entry.lowestPrice == 0 && entry.highestPrice == 0 {
+				continue
+			}
+			if lowestPrice > entry.lowestPrice {
+				lowestIndex = index
+				lowestPrice = entry.lowestPrice
+			}
+			if highestPrice < entry.highestPrice {
+				highestIndex = index
+				highestPrice = entry.highestPrice
+			}
+		}
+		points[lowestIndex] = currentLowestScore
+		points[highestIndex] = currentHighestScore
+		history[lowestIndex].lowestPrice = 0
+		history[lowestIndex].highestPrice = 0
+		history[highestIndex].lowestPrice = 0
+		history[highestIndex].highestPrice = 0
+		currentHighestScore = currentHighestScore - diffIncrement
+		currentLowestScore = currentLowestScore + diffIncrement
+	}
+	return points
+}
+
+// Candles with only a low and high, the rest is unused by the rank labeler
+func lowHighCandles(prices ...[2]float64) []HistoricalEntry {
+	history := make([]HistoricalEntry, len(prices))
+	for index, price := range prices {
+		history[index] = HistoricalEntry{lowestPrice: price[0], highestPrice: price[1]}
+	}
+	return history
+}
+
+// Candles with a close, low and high
+func closeCandles(prices ...[3]float64) []HistoricalEntry {
+	history := make([]HistoricalEntry, len(prices))
+	for index, price := range prices {
+		history[index] = HistoricalEntry{lastTradePrice: price[0], lowestPrice: price[1], highestPrice: price[2]}
+	}
+	return history
+}
+
+// Candles with only a close
+func closeOnly(prices ...float64) []HistoricalEntry {
+	history := make([]HistoricalEntry, len(prices))
+	for index, price := range prices {
+		history[index] = HistoricalEntry{lastTradePrice: price, lowestPrice: price, highestPrice: price}
+	}
+	return history
+}
+
+func checkLabels(t *testing.T, name string, labels []float64, expected []float64) {
+	if len(labels) != len(expected) {
+		t.Fatalf("%s: expected %d labels, got %d", name, len(expected), len(labels))
+	}
+	for index := range expected {
+		if math.Abs(labels[index]-expected[index]) > 1e-9 {
+			t.Fatalf("%s: expected %v, got %v", name, expected, labels)
+		}
+	}
+}
+
+// The legacy search starts from the first candle, once that one is sorted it keeps picking it as the
+// low, so each history leaves the first candle for last
+func TestRankLabelerMatchesComputePoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  []HistoricalEntry
+		expected []float64
+	}{
+		{"spread out", lowHighCandles([2]float64{100, 102}, [2]float64{95, 97}, [2]float64{104, 108}, [2]float64{98, 101}, [2]float64{101, 105}),
+			[]float64{0.6, 0, 1, 0.2, 0.8}},
+		{"ties and an empty candle", lowHighCandles([2]float64{100, 102}, [2]float64{0, 0}, [2]float64{97, 103}, [2]float64{97, 99}, [2]float64{101, 106}, [2]float64{98, 103}),
+			[]float64{4.0 / 6, 0, 0, 1.0 / 6, 1, 5.0 / 6}},
+		{"candle with both extremes", lowHighCandles([2]float64{100, 101}, [2]float64{90, 110}, [2]float64{95, 105}),
+			[]float64{1.0 / 3, 1, 2.0 / 3}},
+	}
+	for _, test := range tests {
+		labels := rankLabeler{}.Label(test.history)
+		checkLabels(t, test.name, labels, test.expected)
+		checkLabels(t, test.name+" against computePoints", labels, legacyPoints(test.history))
+	}
+}
+
+func TestForwardReturnLabeler(t *testing.T) {
+	labeler := forwardReturnLabeler{horizon: 1, threshold: 0.01}
+	// +2% buy, -2.9% sell, +2% buy, -1% under the threshold, no candle ahead
+	checkLabels(t, "closes", labeler.Label(closeOnly(100, 102, 99, 101, 100)), []float64{0, 1, 0, 0.5, 0.5})
+	checkLabels(t, "no trades", labeler.Label(closeOnly(0, 120)), []float64{0.5, 0.5})
+}
+
+func TestTripleBarrierLabeler(t *testing.T) {
+	labeler := tripleBarrierLabeler{horizon: 2, takeProfit: 0.02, stopLoss: 0.01}
+	history := closeCandles(
+		[3]float64{100, 99.5, 100.5},
+		[3]float64{100, 99.5, 101},
+		[3]float64{101, 100, 101.8},
+		[3]float64{100, 98, 104},
+		[3]float64{102, 99.5, 102.5},
+	)
+	// 0: neither 102 nor 99 within two candles, times out
+	// 1: 99 stop-loss hit by the low of candle 3
+	// 2: 99.99 stop-loss and 103.02 take-profit both in candle 3, the loss is assumed
+	// 3: 102 take-profit hit on the last candle
+	// 4: nothing ahead
+	checkLabels(t, "barriers", labeler.Label(history), []float64{0.5, 1, 1, 0, 0.5})
+}
+
+func TestZigZagLabeler(t *testing.T) {
+	labeler := zigZagLabeler{threshold: 0.1}
+	// Swings 100 -> 120 -> 100 -> 115, 105 is 10% under the top at 120 so it turns the first swing
+	checkLabels(t, "swings", labeler.Label(closeOnly(100, 110, 120, 105, 100, 112, 115)), []float64{0, 0.5, 1, 0.25, 0, 0.8, 1})
+	checkLabels(t, "flat", labeler.Label(closeOnly(100, 100, 100)), []float64{0.5, 0.5, 0.5})
+	checkLabels(t, "empty", labeler.Label(nil), []float64{})
+}
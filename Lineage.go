@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// Where a genome came from
+type Lineage struct {
+	ID         string
+	ParentIDs  []string
+	Generation int      // Generation the genome was born in
+	Mutations  []string // Changes applied on top of the parents, in order
+}
+
+// Random identifier for a new genome
+func newGenomeID(rng *rand.Rand) string {
+	return fmt.Sprintf("%016x", rng.Uint64())
+}
+
+// Lineage for a genome born in the next generation from the given parents
+func offspringLineage(rng *rand.Rand, parents ...NeuralNet) Lineage {
+	lineage := Lineage{
+		ID:         newGenomeID(rng),
+		ParentIDs:  make([]string, 0, len(parents)),
+		Generation: generation + 1,
+		Mutations:  make([]string, 0),
+	}
+	for _, parent := range parents {
+		lineage.ParentIDs = append(lineage.ParentIDs, parent.Lineage.ID)
+	}
+	return lineage
+}
+
+func copyLineage(lineage Lineage) Lineage {
+	lineage.ParentIDs = append([]string(nil), lineage.ParentIDs...)
+	lineage.Mutations = append([]string(nil), lineage.Mutations...)
+	return lineage
+}
+
+// File every genome born during training is appended to
+func lineagePath(name string) string {
+	return filepath.Join(checkpointDir(name), "lineage.jsonl")
+}
+
+// Append the lineage of every genome born in the given generation
+func recordLineage(name string, nets []NeuralNet, born int) error {
+	if err := os.MkdirAll(checkpointDir(name), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(lineagePath(name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, net := range nets {
+		if net.Lineage.Generation != born {
+			continue
+		}
+		if err := encoder.Encode(net.Lineage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk back from a genome to its oldest recorded ancestor, following the first parent
+func traceLineage(name string, id string) ([]Lineage, error) {
+	file, err := os.Open(lineagePath(name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	genomes := make(map[string]Lineage)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var lineage Lineage
+		if err := json.Unmarshal(scanner.Bytes(), &lineage); err != nil {
+			return nil, err
+		}
+		genomes[lineage.ID] = lineage
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	chain := make([]Lineage, 0)
+	next := id
+	for next != "" && len(chain) < len(genomes) {
+		lineage, ok := genomes[next]
+		if !ok {
+			break
+		}
+		chain = append(chain, lineage)
+		next = ""
+		if len(lineage.ParentIDs) > 0 {
+			next = lineage.ParentIDs[0]
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("genome " + id + " has no recorded lineage")
+	}
+	return chain, nil
+}
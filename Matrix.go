@@ -0,0 +1,238 @@
+package main
+
+// Layer with its weights stored row major in one contiguous slice, one row per neuron
+type DenseLayer struct {
+	Inputs     int
+	Outputs    int
+	Weights    []float64 // Outputs x Inputs
+	Bias       []float64
+	Activation Activation
+}
+
+// Contiguous copy of a NeuralNet used for fast inference, the Neuron structs remain the format
+// bots are mutated, bred and saved in
+type DenseNet struct {
+	Recurrent *RecurrentLayer
+	Layers    []DenseLayer
+}
+
+// Single precision copy of a DenseNet, roughly halves the memory traffic at the cost of accuracy
+type DenseNet32 struct {
+	Recurrent *RecurrentLayer
+	Layers    []DenseLayer32
+}
+
+type DenseLayer32 struct {
+	Inputs     int
+	Outputs    int
+	Weights    []float32
+	Bias       []float32
+	Activation Activation
+}
+
+// Import a net into the dense layout
+func newDenseNet(net NeuralNet) DenseNet {
+	dense := DenseNet{Recurrent: net.Recurrent, Layers: make([]DenseLayer, len(net.HiddenLayers)+1)}
+	for layer := range dense.Layers {
+		neurons := getLayerNeurons(layer, net)
+		inputs := 0
+		if len(neurons) > 0 {
+			inputs = len(neurons[0].Weights)
+		}
+		dense.Layers[layer] = DenseLayer{
+			Inputs:     inputs,
+			Outputs:    len(neurons),
+			Weights:    make([]float64, len(neurons)*inputs),
+			Bias:       make([]float64, len(neurons)),
+			Activation: layerActivation(layer, net),
+		}
+		for index, neuron := range neurons {
+			copy(dense.Layers[layer].Weights[index*inputs:(index+1)*inputs], neuron.Weights)
+			dense.Layers[layer].Bias[index] = neuron.Bias
+		}
+	}
+	return dense
+}
+
+// Export the dense weights back into Neuron structs, the layer activations and lineage come from net
+func (dense DenseNet) Neurons(net NeuralNet) NeuralNet {
+	net.HiddenLayers = make([][]Neuron, len(dense.Layers)-1)
+	for layer, values := range dense.Layers {
+		neurons := make([]Neuron, values.Outputs)
+		for index := range neurons {
+			neurons[index] = Neuron{
+				Bias:    values.Bias[index],
+				Weights: append([]float64(nil), values.Weights[index*values.Inputs:(index+1)*values.Inputs]...),
+			}
+		}
+		if layer < len(net.HiddenLayers) {
+			net.HiddenLayers[layer] = neurons
+		} else {
+			net.OutputLayer = neurons
+		}
+	}
+	return net
+}
+
+// Single precision copy of the net
+func (dense DenseNet) Float32() DenseNet32 {
+	single := DenseNet32{Recurrent: dense.Recurrent, Layers: make([]DenseLayer32, len(dense.Layers))}
+	for index, layer := range dense.Layers {
+		single.Layers[index] = DenseLayer32{
+			Inputs:     layer.Inputs,
+			Outputs:    layer.Outputs,
+			Weights:    make([]float32, len(layer.Weights)),
+			Bias:       make([]float32, len(layer.Bias)),
+			Activation: layer.Activation,
+		}
+		for weight, value := range layer.Weights {
+			single.Layers[index].Weights[weight] = float32(value)
+		}
+		for bias, value := range layer.Bias {
+			single.Layers[index].Bias[bias] = float32(value)
+		}
+	}
+	return single
+}
+
+// Run every input through the net at once, inputs are ordered oldest first so a recurrent layer sees them in sequence
+func (dense DenseNet) Forward(inputs [][]float64) [][]float64 {
+	batch, width := flattenBatch(dense.Recurrent, inputs)
+	for _, layer := range dense.Layers {
+		output := make([]float64, len(inputs)*layer.Outputs)
+		for row := 0; row < len(inputs); row++ {
+			in := batch[row*width : (row+1)*width]
+			out := output[row*layer.Outputs : (row+1)*layer.Outputs]
+			for neuron := range out {
+				out[neuron] = layer.Bias[neuron] + dot(layer.Weights[neuron*layer.Inputs:(neuron+1)*layer.Inputs], in)
+			}
+		}
+		activateBatch(layer.Activation, output, layer.Outputs)
+		batch = output
+		width = layer.Outputs
+	}
+	return splitBatch(batch, len(inputs), width)
+}
+
+// Forward pass in single precision, activations are still applied in double precision
+func (dense DenseNet32) Forward(inputs [][]float64) [][]float64 {
+	values, width := flattenBatch(dense.Recurrent, inputs)
+	batch := make([]float32, len(values))
+	for index, value := range values {
+		batch[index] = float32(value)
+	}
+	scratch := make([]float64, 0)
+	for _, layer := range dense.Layers {
+		output := make([]float32, len(inputs)*layer.Outputs)
+		for row := 0; row < len(inputs); row++ {
+			in := batch[row*width : (row+1)*width]
+			out := output[row*layer.Outputs : (row+1)*layer.Outputs]
+			for neuron := range out {
+				out[neuron] = layer.Bias[neuron] + dot32(layer.Weights[neuron*layer.Inputs:(neuron+1)*layer.Inputs], in)
+			}
+		}
+		scratch = scratch[:0]
+		for _, value := range output {
+			scratch = append(scratch, float64(value))
+		}
+		activateBatch(layer.Activation, scratch, layer.Outputs)
+		for index, value := range scratch {
+			output[index] = float32(value)
+		}
+		batch = output
+		width = layer.Outputs
+	}
+	outputs := make([]float64, len(batch))
+	for index, value := range batch {
+		outputs[index] = float64(value)
+	}
+	return splitBatch(outputs, len(inputs), width)
+}
+
+// Apply an activation to every row of a batch, only softmax needs to see the rows separately
+func activateBatch(activation Activation, batch []float64, width int) {
+	if activation != ActivationSoftmax {
+		activate(activation, batch)
+		return
+	}
+	for row := 0; row+width <= len(batch); row += width {
+		activate(activation, batch[row:row+width])
+	}
+}
+
+// Dot product of the weights with the start of the inputs, unrolled so the sums can run in parallel
+func dot(weights []float64, inputs []float64) float64 {
+	inputs = inputs[:len(weights)]
+	var a, b, c, d float64
+	index := 0
+	for ; index+4 <= len(weights); index += 4 {
+		a += weights[index] * inputs[index]
+		b += weights[index+1] * inputs[index+1]
+		c += weights[index+2] * inputs[index+2]
+		d += weights[index+3] * inputs[index+3]
+	}
+	for ; index < len(weights); index++ {
+		a += weights[index] * inputs[index]
+	}
+	return a + b + c + d
+}
+
+func dot32(weights []float32, inputs []float32) float32 {
+	inputs = inputs[:len(weights)]
+	var a, b, c, d float32
+	index := 0
+	for ; index+4 <= len(weights); index += 4 {
+		a += weights[index] * inputs[index]
+		b += weights[index+1] * inputs[index+1]
+		c += weights[index+2] * inputs[index+2]
+		d += weights[index+3] * inputs[index+3]
+	}
+	for ; index < len(weights); index++ {
+		a += weights[index] * inputs[index]
+	}
+	return a + b + c + d
+}
+
+// Copy the inputs into one contiguous slice, running them through the recurrent layer first when there is one
+func flattenBatch(recurrent *RecurrentLayer, inputs [][]float64) ([]float64, int) {
+	if recurrent != nil {
+		layer := withFreshState(NeuralNet{Recurrent: recurrent}).Recurrent
+		states := make([][]float64, len(inputs))
+		for index, input := range inputs {
+			states[index] = layer.step(input)
+		}
+		inputs = states
+	}
+	width := 0
+	if len(inputs) > 0 {
+		width = len(inputs[0])
+	}
+	batch := make([]float64, len(inputs)*width)
+	for index, input := range inputs {
+		copy(batch[index*width:(index+1)*width], input)
+	}
+	return batch, width
+}
+
+func splitBatch(batch []float64, rows int, width int) [][]float64 {
+	outputs := make([][]float64, rows)
+	for index := range outputs {
+		outputs[index] = batch[index*width : (index+1)*width]
+	}
+	return outputs
+}
+
+// Net imported for batched inference, each Forward starts from a fresh recurrent state so one can be
+// shared across windows and goroutines
+type BatchNet interface {
+	Forward(inputs [][]float64) [][]float64
+}
+
+// Import a net for batched inference, in single precision when single is set
+func newBatchNet(net NeuralNet, single bool) BatchNet {
+	dense := newDenseNet(net)
+	if single {
+		return dense.Float32()
+	}
+	return dense
+}
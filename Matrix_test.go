@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Net and window sized like a default training run
+func benchmarkNet() (NeuralNet, [][]float64) {
+	rng := rand.New(rand.NewSource(1))
+	net := withActivations(RandomNet(rng, 15, 3, []int{12, 12, 12}, 3), ActivationSigmoid, ActivationSoftmax)
+	inputs := make([][]float64, 3600)
+	for index := range inputs {
+		inputs[index] = make([]float64, 15)
+		for input := range inputs[index] {
+			inputs[index][input] = rng.NormFloat64()
+		}
+	}
+	return net, inputs
+}
+
+func TestDenseMatchesCompute(t *testing.T) {
+	net, inputs := benchmarkNet()
+	inputs = inputs[:100]
+	dense := newDenseNet(net).Forward(inputs)
+	single := newDenseNet(net).Float32().Forward(inputs)
+	for index, input := range inputs {
+		expected := Compute(input, net)
+		for output := range expected {
+			if math.Abs(dense[index][output]-expected[output]) > 1e-12 {
+				t.Fatalf("row %d output %d: dense %v, struct %v", index, output, dense[index][output], expected[output])
+			}
+			if math.Abs(single[index][output]-expected[output]) > 1e-4 {
+				t.Fatalf("row %d output %d: float32 %v, struct %v", index, output, single[index][output], expected[output])
+			}
+		}
+	}
+	exported := newDenseNet(net).Neurons(net)
+	if checkTopology(exported, netTopology(net)) != nil || exported.OutputLayer[1].Weights[4] != net.OutputLayer[1].Weights[4] {
+		t.Fatal("exported neurons do not match the original net")
+	}
+}
+
+func BenchmarkComputeStruct(b *testing.B) {
+	net, inputs := benchmarkNet()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, input := range inputs {
+			Compute(input, net)
+		}
+	}
+}
+
+func BenchmarkForwardDense(b *testing.B) {
+	net, inputs := benchmarkNet()
+	dense := newBatchNet(net, false)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		dense.Forward(inputs)
+	}
+}
+
+func BenchmarkForwardDense32(b *testing.B) {
+	net, inputs := benchmarkNet()
+	single := newBatchNet(net, true)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		single.Forward(inputs)
+	}
+}
+
+// Importing the net is included, as it is done once per bot each generation
+func BenchmarkImportForwardDense(b *testing.B) {
+	net, inputs := benchmarkNet()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		newBatchNet(net, false).Forward(inputs)
+	}
+}
+
+func BenchmarkImportForwardDense32(b *testing.B) {
+	net, inputs := benchmarkNet()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		newBatchNet(net, true).Forward(inputs)
+	}
+}
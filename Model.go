@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Current version of the saved model format, bump when the layout changes
+// 1: Initial format, nets from before the forward pass used the hidden layers are rejected (see legacyForwardPass)
+// 2: Per layer activations, Neuron.Activation removed (version 1 models load as all sigmoid)
+// 3: FeatureSet holds the feature specs the bot was trained on (older models load with the legacy inputs)
+// 4: Input normalizer stored (older models use unscaled inputs)
+// 5: Lookback and optional recurrent layer stored (older models use a lookback of 1)
+const modelVersion = 5
+
+type ModelMetadata struct {
+	Name              string
+	Market            string
+	Generation        int
+	Fitness           float64
+	ValidationFitness float64 // Out of sample fitness when trained with walk-forward
+	Seed              int64
+	FeatureSet        []string
+	Lookback          int
+	Normalizer        Normalizer // Fitted on the training inputs, applied after the features
+	SavedAt           int64
+}
+
+type ModelTopology struct {
+	InputSize     int
+	Recurrent     string // Cell of the recurrent layer, empty without one
+	RecurrentSize int
+	HiddenLayers  []int
+	OutputSize    int
+}
+
+type SavedModel struct {
+	Version  int
+	Metadata ModelMetadata
+	Topology ModelTopology
+	Net      NeuralNet
+}
+
+// Location of a named model on disk
+func modelPath(name string) string {
+	return filepath.Join(BaseDir, "models", name+".json")
+}
+
+// Describe the layer sizes of a net
+func netTopology(net NeuralNet) ModelTopology {
+	topology := ModelTopology{
+		HiddenLayers: make([]int, len(net.HiddenLayers)),
+		OutputSize:   len(net.OutputLayer),
+	}
+	for index, layer := range net.HiddenLayers {
+		topology.HiddenLayers[index] = len(layer)
+	}
+	if net.Recurrent != nil && len(net.Recurrent.Units) > 0 {
+		topology.Recurrent = net.Recurrent.Cell
+		topology.RecurrentSize = len(net.Recurrent.Units)
+		topology.InputSize = len(net.Recurrent.Units[0].Weights) - topology.RecurrentSize
+	} else if len(net.HiddenLayers) > 0 && len(net.HiddenLayers[0]) > 0 {
+		topology.InputSize = len(net.HiddenLayers[0][0].Weights)
+	}
+	return topology
+}
+
+// Save a net along with its metadata to the given file
+func SaveNet(path string, net NeuralNet, metadata ModelMetadata) error {
+	if net.HiddenLayers == nil {
+		return errors.New("cannot save an empty net")
+	}
+	metadata.SavedAt = time.Now().Unix()
+	data, err := json.MarshalIndent(SavedModel{
+		Version:  modelVersion,
+		Metadata: metadata,
+		Topology: netTopology(net),
+		Net:      net,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write to a temp file first so a crash never leaves a half written model
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load a net and its metadata from the given file
+func LoadNet(path string) (NeuralNet, ModelMetadata, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NeuralNet{}, ModelMetadata{}, err
+	}
+	var model SavedModel
+	if err := json.Unmarshal(data, &model); err != nil {
+		return NeuralNet{}, ModelMetadata{}, err
+	}
+	if model.Version < 1 || model.Version > modelVersion {
+		return NeuralNet{}, ModelMetadata{}, fmt.Errorf("unsupported model version %d", model.Version)
+	}
+	if model.Version == 1 && legacyForwardPass(model.Topology) {
+		return NeuralNet{}, ModelMetadata{}, errors.New("model was saved before the forward pass used its hidden layers (14 inputs, 13 outputs), it has to be retrained")
+	}
+	if err := checkTopology(model.Net, model.Topology); err != nil {
+		return NeuralNet{}, ModelMetadata{}, err
+	}
+	if err := checkActivations(model.Net); err != nil {
+		return NeuralNet{}, ModelMetadata{}, err
+	}
+	if model.Version < 3 {
+		model.Metadata.FeatureSet = legacyFeatureSet
+	}
+	if model.Version < 5 {
+		model.Metadata.Lookback = 1
+	}
+	if model.Metadata.Lookback < 1 || model.Topology.InputSize%model.Metadata.Lookback != 0 {
+		return NeuralNet{}, ModelMetadata{}, fmt.Errorf("invalid lookback %d", model.Metadata.Lookback)
+	}
+	if err := model.Metadata.Normalizer.check(model.Topology.InputSize / model.Metadata.Lookback); err != nil {
+		return NeuralNet{}, ModelMetadata{}, err
+	}
+	return model.Net, model.Metadata, nil
+}
+
+// Whether a version 1 net has the shape bots had before the forward pass used the hidden layers. Those
+// bots skipped their hidden layers and ran the output layer repeatedly, their weights mean nothing to the
+// current forward pass. Later version 1 files were already written with 13 inputs and 3 outputs.
+func legacyForwardPass(topology ModelTopology) bool {
+	return topology.InputSize == 14 && topology.OutputSize == 13
+}
+
+// Ensure the loaded weights match the recorded topology
+func checkTopology(net NeuralNet, topology ModelTopology) error {
+	if len(net.HiddenLayers) != len(topology.HiddenLayers) || len(net.OutputLayer) != topology.OutputSize {
+		return errors.New("model layers do not match its topology")
+	}
+	if err := checkRecurrent(net.Recurrent, topology); err != nil {
+		return err
+	}
+	inputs := topology.InputSize
+	if net.Recurrent != nil {
+		inputs = topology.RecurrentSize
+	}
+	for layer, neurons := range net.HiddenLayers {
+		if len(neurons) != topology.HiddenLayers[layer] {
+			return fmt.Errorf("hidden layer %d has %d neurons, expected %d", layer, len(neurons), topology.HiddenLayers[layer])
+		}
+		for _, neuron := range neurons {
+			if len(neuron.Weights) != inputs {
+				return fmt.Errorf("hidden layer %d has a neuron with %d weights, expected %d", layer, len(neuron.Weights), inputs)
+			}
+		}
+		inputs = len(neurons)
+	}
+	for _, neuron := range net.OutputLayer {
+		if len(neuron.Weights) != inputs {
+			return fmt.Errorf("output layer has a neuron with %d weights, expected %d", len(neuron.Weights), inputs)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write a version 1 model file holding the given net
+func writeVersion1Model(t *testing.T, net NeuralNet) string {
+	data, err := json.Marshal(SavedModel{Version: 1, Metadata: ModelMetadata{Name: "old"}, Topology: netTopology(net), Net: net})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "old.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadVersion1Model(t *testing.T) {
+	// Shape written once the forward pass used the hidden layers, before activations were stored
+	net := RandomNet(rand.New(newRngSource(1)), 13, 3, []int{12, 12, 12}, 3)
+	loaded, metadata, err := LoadNet(writeVersion1Model(t, net))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameFeatures(metadata.FeatureSet, legacyFeatureSet) || metadata.Lookback != 1 || layerActivation(3, loaded) != ActivationSigmoid {
+		t.Fatalf("expected the legacy inputs and sigmoid outputs, got %+v", metadata)
+	}
+}
+
+func TestRejectModelFromBeforeHiddenLayers(t *testing.T) {
+	// Shape of the bots whose forward pass skipped the hidden layers
+	net := RandomNet(rand.New(newRngSource(1)), 14, 3, []int{12, 12, 12}, 13)
+	if _, _, err := LoadNet(writeVersion1Model(t, net)); err == nil || !strings.Contains(err.Error(), "retrained") {
+		t.Fatalf("expected the model to be rejected, got %v", err)
+	}
+}
@@ -4,21 +4,51 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
-	"time"
 )
 
 type Neuron struct {
-	Bias       float64
-	Activation float64
-	Weights    []float64
+	Bias    float64
+	Weights []float64
 }
 
 type NeuralNet struct {
-	HiddenLayers [][]Neuron
-	OutputLayer  []Neuron
+	HiddenLayers      [][]Neuron
+	OutputLayer       []Neuron
+	HiddenActivations []Activation
+	OutputActivation  Activation
+	Recurrent         *RecurrentLayer // Optional, nil for a plain feed forward net
+	Lineage           Lineage
 }
 
+// Deep copy of the net, the copy shares no memory with the original
+func (net NeuralNet) Clone() NeuralNet {
+	clone := NeuralNet{
+		HiddenLayers:      make([][]Neuron, len(net.HiddenLayers)),
+		OutputLayer:       make([]Neuron, len(net.OutputLayer)),
+		HiddenActivations: append([]Activation(nil), net.HiddenActivations...),
+		OutputActivation:  net.OutputActivation,
+		Lineage:           copyLineage(net.Lineage),
+	}
+	if net.Recurrent != nil {
+		clone.Recurrent = net.Recurrent.clone()
+	}
+	for layer, neurons := range net.HiddenLayers {
+		clone.HiddenLayers[layer] = make([]Neuron, len(neurons))
+		for index, neuron := range neurons {
+			clone.HiddenLayers[layer][index] = copyNeuron(neuron)
+		}
+	}
+	for index, neuron := range net.OutputLayer {
+		clone.OutputLayer[index] = copyNeuron(neuron)
+	}
+	return clone
+}
+
+// Run an input through the net, a recurrent net carries its state over to the next call
 func Compute(input []float64, net NeuralNet) []float64 {
+	if net.Recurrent != nil {
+		input = net.Recurrent.step(input)
+	}
 	// Calculate each layer
 	for layer := 0; layer < len(net.HiddenLayers)+1; layer++ {
 		input = calculateLayer(input, layer, net)
@@ -34,8 +64,9 @@ func calculateLayer(activation []float64, layer int, net NeuralNet) []float64 {
 		for weight := 0; weight < len(layerNeurons[index].Weights); weight++ {
 			total += layerNeurons[index].Weights[weight] * activation[weight]
 		}
-		output[index] = sigmoid(total + layerNeurons[index].Bias)
+		output[index] = total + layerNeurons[index].Bias
 	}
+	activate(layerActivation(layer, net), output)
 	return output
 }
 
@@ -44,14 +75,14 @@ func sigmoid(num float64) float64 {
 }
 
 func getLayerNeurons(layer int, net NeuralNet) []Neuron {
-	if layer > len(net.HiddenLayers) {
+	if layer < len(net.HiddenLayers) {
 		return net.HiddenLayers[layer]
 	} else {
 		return net.OutputLayer
 	}
 }
 
-func RandomNet(inputSize int, hiddenLayerCount int, hiddenLayer []int, outputLayerSize int) NeuralNet {
+func RandomNet(rng *rand.Rand, inputSize int, hiddenLayerCount int, hiddenLayer []int, outputLayerSize int) NeuralNet {
 	if hiddenLayerCount != len(hiddenLayer) {
 		fmt.Println("Invalid Neural-Net Config")
 		return NeuralNet{}
@@ -59,15 +90,15 @@ func RandomNet(inputSize int, hiddenLayerCount int, hiddenLayer []int, outputLay
 	outputLayer := make([]Neuron, outputLayerSize)
 	hiddenLayers := make([][]Neuron, hiddenLayerCount)
 	for index := 0; index < outputLayerSize; index++ {
-		outputLayer[index] = RandomNeuron(hiddenLayer[len(hiddenLayer)-1], 5.0)
+		outputLayer[index] = RandomNeuron(rng, hiddenLayer[len(hiddenLayer)-1], 5.0)
 	}
 	for layer := 0; layer < hiddenLayerCount; layer++ {
 		hiddenLayers[layer] = make([]Neuron, hiddenLayer[layer])
 		for index := 0; index < hiddenLayer[layer]; index++ {
 			if layer == 0 {
-				hiddenLayers[layer][index] = RandomNeuron(inputSize, 5.0)
+				hiddenLayers[layer][index] = RandomNeuron(rng, inputSize, 5.0)
 			} else {
-				hiddenLayers[layer][index] = RandomNeuron(hiddenLayer[layer], 5.0)
+				hiddenLayers[layer][index] = RandomNeuron(rng, hiddenLayer[layer-1], 5.0)
 			}
 		}
 	}
@@ -77,15 +108,23 @@ func RandomNet(inputSize int, hiddenLayerCount int, hiddenLayer []int, outputLay
 	}
 }
 
-func RandomNeuron(weightsCount int, highestWeight float64) Neuron {
+// Use the given activation on every hidden layer and on the output layer
+func withActivations(net NeuralNet, hidden Activation, output Activation) NeuralNet {
+	net.HiddenActivations = make([]Activation, len(net.HiddenLayers))
+	for layer := range net.HiddenActivations {
+		net.HiddenActivations[layer] = hidden
+	}
+	net.OutputActivation = output
+	return net
+}
+
+func RandomNeuron(rng *rand.Rand, weightsCount int, highestWeight float64) Neuron {
 	weights := make([]float64, weightsCount)
-	rand.Seed(time.Now().UnixNano() - int64(rand.Int())) // Yep you see that correctly
 	for index := 0; index < weightsCount; index++ {
-		weights[index] = rand.Float64() * highestWeight
+		weights[index] = rng.Float64() * highestWeight
 	}
 	return Neuron{
-		Bias:       rand.Float64() * highestWeight,
-		Activation: rand.Float64() * highestWeight,
-		Weights:    weights,
+		Bias:    rng.Float64() * highestWeight,
+		Weights: weights,
 	}
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Scales each input of the feature pipeline. Fitted once on training inputs and stored with the
+// model, so live trading and backtests see inputs scaled exactly like the bot was trained on.
+type Normalizer struct {
+	Method string    // none, zscore, minmax, robust or rolling_zscore
+	Window int       // Rows used by rolling_zscore
+	Center []float64 // Subtracted from each input
+	Scale  []float64 // Divides each input after centering
+}
+
+// Check the normalization settings are usable
+func validateNormalization(method string, window int) error {
+	switch method {
+	case "", "none", "zscore", "minmax", "robust": // Empty for checkpoints from before normalization existed
+		return nil
+	case "rolling_zscore":
+		if window < 2 {
+			return errors.New("normalization_window must be at least 2")
+		}
+		return nil
+	}
+	return errors.New("unknown normalization '" + method + "'")
+}
+
+// Fit a normalizer on the inputs of the training data
+func fitNormalizer(method string, window int, inputs [][]float64) Normalizer {
+	normalizer := Normalizer{Method: method, Window: window}
+	if method != "zscore" && method != "minmax" && method != "robust" {
+		return normalizer
+	}
+	if len(inputs) == 0 {
+		return normalizer
+	}
+	width := len(inputs[0])
+	normalizer.Center = make([]float64, width)
+	normalizer.Scale = make([]float64, width)
+	column := make([]float64, len(inputs))
+	for input := 0; input < width; input++ {
+		for index, row := range inputs {
+			column[index] = row[input]
+		}
+		switch method {
+		case "zscore":
+			normalizer.Center[input], normalizer.Scale[input] = meanDeviation(column)
+		case "minmax":
+			sort.Float64s(column)
+			normalizer.Center[input] = column[0]
+			normalizer.Scale[input] = column[len(column)-1] - column[0]
+		case "robust":
+			sort.Float64s(column)
+			normalizer.Center[input] = quantile(column, 0.5)
+			normalizer.Scale[input] = quantile(column, 0.75) - quantile(column, 0.25)
+		}
+		// Constant inputs are only centered
+		if normalizer.Scale[input] <= 0 {
+			normalizer.Scale[input] = 1
+		}
+	}
+	return normalizer
+}
+
+// Copy of the pipeline with the configured normalizer fitted on the training candles
+func (pipeline FeaturePipeline) Fit(settings TrainingSettings, candles []HistoricalEntry) FeaturePipeline {
+	pipeline.Normalizer = fitNormalizer(settings.Normalization, settings.NormalizationWindow, pipeline.Raw(candles))
+	return pipeline
+}
+
+// Scale the inputs in place, rows are sorted oldest first
+func (normalizer Normalizer) Apply(inputs [][]float64) {
+	if normalizer.Method == "rolling_zscore" {
+		// Walk backwards so every row is scaled against the raw rows before it
+		column := make([]float64, 0, normalizer.Window)
+		for index := len(inputs) - 1; index >= 0; index-- {
+			start := index + 1 - normalizer.Window
+			if start < 0 {
+				start = 0
+			}
+			for input := range inputs[index] {
+				column = column[:0]
+				for row := start; row <= index; row++ {
+					column = append(column, inputs[row][input])
+				}
+				mean, deviation := meanDeviation(column)
+				inputs[index][input] -= mean
+				if deviation > 0 {
+					inputs[index][input] /= deviation
+				}
+			}
+		}
+		return
+	}
+	if len(normalizer.Center) == 0 {
+		return
+	}
+	for _, row := range inputs {
+		for input := range row {
+			row[input] = (row[input] - normalizer.Center[input]) / normalizer.Scale[input]
+		}
+	}
+}
+
+// Check a fitted normalizer can scale inputs of the given width
+func (normalizer Normalizer) check(width int) error {
+	if len(normalizer.Center) != 0 && (len(normalizer.Center) != width || len(normalizer.Scale) != width) {
+		return errors.New("normalizer does not match the input size")
+	}
+	for _, scale := range normalizer.Scale {
+		if scale == 0 || math.IsNaN(scale) {
+			return errors.New("normalizer has an invalid scale")
+		}
+	}
+	return nil
+}
+
+// Linearly interpolated quantile of sorted values
+func quantile(sorted []float64, q float64) float64 {
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
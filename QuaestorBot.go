@@ -29,12 +29,12 @@ func main() {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		handleCommands(wg)
+		handleCommands(&wg)
 	}()
 	select {}
 }
 
-func handleCommands(wg sync.WaitGroup) {
+func handleCommands(wg *sync.WaitGroup) {
 	for {
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Print(": ")
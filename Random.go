@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Random source whose full state is a single number, so it can be checkpointed and restored (splitmix64)
+type rngSource struct {
+	state uint64
+}
+
+func newRngSource(seed int64) *rngSource {
+	source := &rngSource{}
+	source.Seed(seed)
+	return source
+}
+
+func (source *rngSource) Seed(seed int64) {
+	source.state = uint64(seed)
+}
+
+func (source *rngSource) Uint64() uint64 {
+	source.state += 0x9E3779B97F4A7C15
+	z := source.state
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+func (source *rngSource) Int63() int64 {
+	return int64(source.Uint64() >> 1)
+}
+
+// Current position of the source
+func (source *rngSource) State() uint64 {
+	return source.state
+}
+
+// Continue from a previously recorded position
+func (source *rngSource) Restore(state uint64) {
+	source.state = state
+}
+
+// Seed to use for a run, a configured seed of 0 picks one from the clock
+func pickSeed(configured int64) int64 {
+	if configured != 0 {
+		return configured
+	}
+	return time.Now().UnixNano()
+}
+
+// Randomness for a training run along with its checkpointable source
+func newTrainingRand(seed int64) (*rand.Rand, *rngSource) {
+	source := newRngSource(seed)
+	return rand.New(source), source
+}
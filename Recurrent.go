@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Layer between the inputs and the first hidden layer that remembers its output between Compute calls.
+// Gate weights cover the inputs followed by the previous state.
+type RecurrentLayer struct {
+	Cell   string   // rnn or gru
+	Units  []Neuron // Candidate state
+	Update []Neuron // GRU only, how much of the previous state is kept
+	Reset  []Neuron // GRU only, how much of the previous state feeds the candidate
+	state  []float64
+}
+
+// Check the recurrent settings are usable
+func validateRecurrent(cell string, size int) error {
+	switch cell {
+	case "", "none": // Empty for checkpoints from before recurrent layers existed
+		return nil
+	case "rnn", "gru":
+		if size < 1 {
+			return errors.New("recurrent_size must be at least 1")
+		}
+		return nil
+	}
+	return errors.New("unknown recurrent cell '" + cell + "'")
+}
+
+// Create a recurrent layer with random weights
+func randomRecurrent(rng *rand.Rand, cell string, inputSize int, size int) *RecurrentLayer {
+	gate := func() []Neuron {
+		neurons := make([]Neuron, size)
+		for index := range neurons {
+			neurons[index] = RandomNeuron(rng, inputSize+size, 5.0)
+		}
+		return neurons
+	}
+	layer := &RecurrentLayer{Cell: cell, Units: gate()}
+	if cell == "gru" {
+		layer.Update = gate()
+		layer.Reset = gate()
+	}
+	return layer
+}
+
+// Every set of neurons in the layer, used by mutation and crossover
+func (layer *RecurrentLayer) gates() [][]Neuron {
+	if layer.Cell == "gru" {
+		return [][]Neuron{layer.Units, layer.Update, layer.Reset}
+	}
+	return [][]Neuron{layer.Units}
+}
+
+// Deep copy of the layer with an empty state
+func (layer *RecurrentLayer) clone() *RecurrentLayer {
+	copyGate := func(neurons []Neuron) []Neuron {
+		if neurons == nil {
+			return nil
+		}
+		copied := make([]Neuron, len(neurons))
+		for index, neuron := range neurons {
+			copied[index] = copyNeuron(neuron)
+		}
+		return copied
+	}
+	return &RecurrentLayer{Cell: layer.Cell, Units: copyGate(layer.Units), Update: copyGate(layer.Update), Reset: copyGate(layer.Reset)}
+}
+
+// Feed one input through the layer, updating its state
+func (layer *RecurrentLayer) step(input []float64) []float64 {
+	if layer.state == nil {
+		layer.state = make([]float64, len(layer.Units))
+	}
+	previous := layer.state
+	weighted := func(neuron Neuron, state []float64) float64 {
+		total := neuron.Bias
+		for index, value := range input {
+			total += neuron.Weights[index] * value
+		}
+		for index, value := range state {
+			total += neuron.Weights[len(input)+index] * value
+		}
+		return total
+	}
+	next := make([]float64, len(layer.Units))
+	if layer.Cell == "gru" {
+		reset := make([]float64, len(previous))
+		for index := range reset {
+			reset[index] = sigmoid(weighted(layer.Reset[index], previous)) * previous[index]
+		}
+		for index := range next {
+			update := sigmoid(weighted(layer.Update[index], previous))
+			candidate := math.Tanh(weighted(layer.Units[index], reset))
+			next[index] = (1-update)*candidate + update*previous[index]
+		}
+	} else {
+		for index := range next {
+			next[index] = math.Tanh(weighted(layer.Units[index], previous))
+		}
+	}
+	layer.state = next
+	return append([]float64(nil), next...)
+}
+
+// Copy of the net whose recurrent state starts empty, sharing its weights with the original.
+// Each run over a window starts from a fresh state, which also keeps concurrent runs apart.
+func withFreshState(net NeuralNet) NeuralNet {
+	if net.Recurrent != nil {
+		layer := *net.Recurrent
+		layer.state = nil
+		net.Recurrent = &layer
+	}
+	return net
+}
+
+// Ensure the recurrent layer matches the recorded topology
+func checkRecurrent(layer *RecurrentLayer, topology ModelTopology) error {
+	if topology.Recurrent == "" {
+		if layer != nil {
+			return errors.New("model has a recurrent layer its topology does not list")
+		}
+		return nil
+	}
+	if layer == nil || layer.Cell != topology.Recurrent {
+		return errors.New("model recurrent layer does not match its topology")
+	}
+	for _, neurons := range layer.gates() {
+		if len(neurons) != topology.RecurrentSize {
+			return fmt.Errorf("recurrent layer has %d neurons, expected %d", len(neurons), topology.RecurrentSize)
+		}
+		for _, neuron := range neurons {
+			if len(neuron.Weights) != topology.InputSize+topology.RecurrentSize {
+				return fmt.Errorf("recurrent layer has a neuron with %d weights, expected %d", len(neuron.Weights), topology.InputSize+topology.RecurrentSize)
+			}
+		}
+	}
+	return nil
+}
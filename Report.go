@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const secondsPerYear = 365 * 24 * 60 * 60
+
+type BacktestReport struct {
+	Name                string
+	Market              string
+	Start               int64
+	End                 int64
+	Candles             int
+	StartingEquity      float64
+	FinalEquity         float64
+	TotalReturn         float64
+	AnnualizedReturn    float64
+	Sharpe              float64
+	Sortino             float64
+	Calmar              float64
+	MaxDrawdown         float64
+	MaxDrawdownDuration int64 // Seconds from the peak until the equity recovered (or the backtest ended)
+	TradeCount          int
+	WinRate             float64
+	AverageWin          float64
+	AverageLoss         float64
+	Exposure            float64 // Fraction of candles holding base currency
+	BuyAndHoldReturn    float64
+}
+
+// Compute the performance statistics of a backtest
+func GenerateReport(name string, market string, result BacktestResult) BacktestReport {
+	report := BacktestReport{
+		Name:           name,
+		Market:         market,
+		Candles:        len(result.Equity),
+		StartingEquity: result.StartingEquity,
+		FinalEquity:    result.FinalEquity,
+		TradeCount:     len(result.Trades),
+	}
+	if len(result.Equity) == 0 || result.StartingEquity <= 0 {
+		return report
+	}
+	report.Start = result.Timestamps[0]
+	report.End = result.Timestamps[len(result.Timestamps)-1]
+	report.TotalReturn = result.FinalEquity/result.StartingEquity - 1
+	if result.StartingPrice > 0 {
+		report.BuyAndHoldReturn = result.Prices[len(result.Prices)-1]/result.StartingPrice - 1
+	}
+	duration := float64(report.End - report.Start)
+	if duration > 0 && result.FinalEquity > 0 {
+		report.AnnualizedReturn = math.Pow(result.FinalEquity/result.StartingEquity, secondsPerYear/duration) - 1
+	}
+	report.Sharpe, report.Sortino = riskAdjustedReturns(result)
+	report.MaxDrawdown, report.MaxDrawdownDuration = maxDrawdown(result)
+	if report.MaxDrawdown > 0 {
+		report.Calmar = report.AnnualizedReturn / report.MaxDrawdown
+	}
+	report.WinRate, report.AverageWin, report.AverageLoss = tradeOutcomes(result.Trades)
+	held := 0
+	for _, base := range result.Base {
+		if base > 0 {
+			held++
+		}
+	}
+	report.Exposure = float64(held) / float64(len(result.Base))
+	return report
+}
+
+// Typical time between candles in seconds
+func candleInterval(timestamps []int64) float64 {
+	if len(timestamps) < 2 {
+		return 60
+	}
+	gaps := make([]int64, len(timestamps)-1)
+	for index := 1; index < len(timestamps); index++ {
+		gaps[index-1] = timestamps[index] - timestamps[index-1]
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	if gaps[len(gaps)/2] <= 0 {
+		return 60
+	}
+	return float64(gaps[len(gaps)/2])
+}
+
+// Annualized Sharpe and Sortino ratios of the per candle returns
+func riskAdjustedReturns(result BacktestResult) (float64, float64) {
+	returns := make([]float64, 0, len(result.Equity))
+	previous := result.StartingEquity
+	for _, equity := range result.Equity {
+		if previous > 0 {
+			returns = append(returns, equity/previous-1)
+		}
+		previous = equity
+	}
+	if len(returns) < 2 {
+		return 0, 0
+	}
+	mean := 0.0
+	for _, value := range returns {
+		mean += value
+	}
+	mean /= float64(len(returns))
+	variance := 0.0
+	downside := 0.0
+	for _, value := range returns {
+		variance += (value - mean) * (value - mean)
+		if value < 0 {
+			downside += value * value
+		}
+	}
+	deviation := math.Sqrt(variance / float64(len(returns)-1))
+	downsideDeviation := math.Sqrt(downside / float64(len(returns)))
+	scale := math.Sqrt(secondsPerYear / candleInterval(result.Timestamps))
+	sharpe := 0.0
+	sortino := 0.0
+	if deviation > 0 {
+		sharpe = mean / deviation * scale
+	}
+	if downsideDeviation > 0 {
+		sortino = mean / downsideDeviation * scale
+	}
+	return sharpe, sortino
+}
+
+// Largest fall from a peak in equity, as a fraction, along with how long it took to recover
+func maxDrawdown(result BacktestResult) (float64, int64) {
+	peak := result.StartingEquity
+	peakTime := result.Timestamps[0]
+	worst := 0.0
+	worstDuration := int64(0)
+	for index, equity := range result.Equity {
+		if equity >= peak {
+			peak = equity
+			peakTime = result.Timestamps[index]
+			continue
+		}
+		if drawdown := 1 - equity/peak; drawdown > worst {
+			worst = drawdown
+		}
+		if duration := result.Timestamps[index] - peakTime; duration > worstDuration {
+			worstDuration = duration
+		}
+	}
+	return worst, worstDuration
+}
+
+// Win rate and average profit of round trips, each sell is matched against the average cost of the base held
+func tradeOutcomes(trades []BacktestTrade) (float64, float64, float64) {
+	held := 0.0
+	cost := 0.0 // Quote spent on the base held, including fees
+	wins := make([]float64, 0)
+	losses := make([]float64, 0)
+	for _, trade := range trades {
+		if trade.Side == "buy" {
+			held += trade.Size
+			cost += trade.Size*trade.Price + trade.Fee
+			continue
+		}
+		if held <= 0 {
+			continue
+		}
+		size := math.Min(trade.Size, held)
+		basis := cost * size / held
+		profit := size*trade.Price - trade.Fee - basis
+		cost -= basis
+		held -= size
+		if profit > 0 {
+			wins = append(wins, profit)
+		} else {
+			losses = append(losses, profit)
+		}
+	}
+	average := func(values []float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		total := 0.0
+		for _, value := range values {
+			total += value
+		}
+		return total / float64(len(values))
+	}
+	winRate := 0.0
+	if len(wins)+len(losses) > 0 {
+		winRate = float64(len(wins)) / float64(len(wins)+len(losses))
+	}
+	return winRate, average(wins), average(losses)
+}
+
+func formatDuration(seconds int64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// Plain text report for the console
+func (report BacktestReport) Text() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Backtest %s on %s (%s to %s, %d candles)\n", report.Name, report.Market,
+		time.Unix(report.Start, 0).Format("2006-01-02 15:04"), time.Unix(report.End, 0).Format("2006-01-02 15:04"), report.Candles)
+	fmt.Fprintf(&builder, "  Equity:            %.2f -> %.2f\n", report.StartingEquity, report.FinalEquity)
+	fmt.Fprintf(&builder, "  Total Return:      %.2f%% (Buy & Hold %.2f%%)\n", report.TotalReturn*100, report.BuyAndHoldReturn*100)
+	fmt.Fprintf(&builder, "  Annualized Return: %.2f%%\n", report.AnnualizedReturn*100)
+	fmt.Fprintf(&builder, "  Sharpe:            %.3f\n", report.Sharpe)
+	fmt.Fprintf(&builder, "  Sortino:           %.3f\n", report.Sortino)
+	fmt.Fprintf(&builder, "  Calmar:            %.3f\n", report.Calmar)
+	fmt.Fprintf(&builder, "  Max Drawdown:      %.2f%% over %s\n", report.MaxDrawdown*100, formatDuration(report.MaxDrawdownDuration))
+	fmt.Fprintf(&builder, "  Trades:            %d (Win Rate %.2f%%)\n", report.TradeCount, report.WinRate*100)
+	fmt.Fprintf(&builder, "  Average Win/Loss:  %.4f / %.4f\n", report.AverageWin, report.AverageLoss)
+	fmt.Fprintf(&builder, "  Exposure:          %.2f%%\n", report.Exposure*100)
+	return builder.String()
+}
+
+// Report for other tooling
+func (report BacktestReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(report, "", "  ")
+}
+
+// Short summary formatted for Discord, which does not render tables so each metric gets its own line
+func (report BacktestReport) Markdown() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "**Backtest %s** on `%s` (%d candles)\n", report.Name, report.Market, report.Candles)
+	fmt.Fprintf(&builder, "**Return:** %.2f%%\n", report.TotalReturn*100)
+	fmt.Fprintf(&builder, "**Buy & Hold:** %.2f%%\n", report.BuyAndHoldReturn*100)
+	fmt.Fprintf(&builder, "**Annualized:** %.2f%%\n", report.AnnualizedReturn*100)
+	fmt.Fprintf(&builder, "**Sharpe / Sortino / Calmar:** %.2f / %.2f / %.2f\n", report.Sharpe, report.Sortino, report.Calmar)
+	fmt.Fprintf(&builder, "**Max Drawdown:** %.2f%% (%s)\n", report.MaxDrawdown*100, formatDuration(report.MaxDrawdownDuration))
+	fmt.Fprintf(&builder, "**Trades:** %d (%.1f%% wins)\n", report.TradeCount, report.WinRate*100)
+	fmt.Fprintf(&builder, "**Avg Win / Loss:** %.4f / %.4f\n", report.AverageWin, report.AverageLoss)
+	fmt.Fprintf(&builder, "**Exposure:** %.1f%%\n", report.Exposure*100)
+	return builder.String()
+}
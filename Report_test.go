@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// Daily equity of 100 -> 110 -> 99 -> 118.8, per candle returns of +10%, -10% and +20%
+func reportFixture() BacktestResult {
+	return BacktestResult{
+		Trades: []BacktestTrade{
+			{Side: "buy", Price: 100, Size: 1, Fee: 1},       // Costs 101
+			{Side: "sell", Price: 110, Size: 0.5, Fee: 0.55}, // 55 - 0.55 - 50.5 = 3.95
+			{Side: "sell", Price: 98, Size: 0.5, Fee: 0.49},  // 49 - 0.49 - 50.5 = -1.99
+			{Side: "sell", Price: 120, Size: 1, Fee: 0},      // Nothing held, skipped
+			{Side: "buy", Price: 10, Size: 2, Fee: 0},        // Costs 20
+			{Side: "sell", Price: 12, Size: 2, Fee: 0.24},    // 24 - 0.24 - 20 = 3.76
+		},
+		Timestamps:     []int64{0, 86400, 172800},
+		Prices:         []float64{55, 45, 60},
+		Equity:         []float64{110, 99, 118.8},
+		Base:           []float64{0, 1, 0},
+		StartingPrice:  50,
+		StartingEquity: 100,
+		FinalEquity:    118.8,
+	}
+}
+
+func TestGenerateReport(t *testing.T) {
+	report := GenerateReport("fixture", "BTC-USD", reportFixture())
+	// Returns of 0.1, -0.1 and 0.2: mean 0.2/3, sample variance 0.07/3, downside variance 0.01/3, annualized by sqrt(365)
+	annualized := math.Pow(1.188, 365.0/2) - 1
+	expected := []struct {
+		name   string
+		actual float64
+		value  float64
+	}{
+		{"total return", report.TotalReturn, 0.188},
+		{"buy and hold", report.BuyAndHoldReturn, 0.2},
+		{"annualized return", report.AnnualizedReturn, annualized},
+		{"sharpe", report.Sharpe, (0.2 / 3) / math.Sqrt(0.07/3) * math.Sqrt(365)},
+		{"sortino", report.Sortino, (0.2 / 3) / math.Sqrt(0.01/3) * math.Sqrt(365)},
+		{"max drawdown", report.MaxDrawdown, 0.1},
+		{"calmar", report.Calmar, annualized / 0.1},
+		{"win rate", report.WinRate, 2.0 / 3},
+		{"average win", report.AverageWin, (3.95 + 3.76) / 2},
+		{"average loss", report.AverageLoss, -1.99},
+		{"exposure", report.Exposure, 1.0 / 3},
+	}
+	for _, metric := range expected {
+		if math.Abs(metric.actual-metric.value) > 1e-9*math.Max(1, math.Abs(metric.value)) {
+			t.Errorf("%s: expected %v, got %v", metric.name, metric.value, metric.actual)
+		}
+	}
+	if report.MaxDrawdownDuration != 86400 {
+		t.Errorf("expected the drawdown to last a day, got %d seconds", report.MaxDrawdownDuration)
+	}
+	if report.Start != 0 || report.End != 172800 || report.Candles != 3 || report.TradeCount != 6 {
+		t.Errorf("unexpected report range %+v", report)
+	}
+}
+
+func TestDrawdownWithoutRecovery(t *testing.T) {
+	result := BacktestResult{Timestamps: []int64{0, 60, 120, 180}, Equity: []float64{120, 90, 60, 80}, StartingEquity: 100}
+	// Peak of 120 falls to 60 and is still below it when the backtest ends
+	drawdown, duration := maxDrawdown(result)
+	if math.Abs(drawdown-0.5) > 1e-12 || duration != 180 {
+		t.Fatalf("expected a 50%% drawdown over 180 seconds, got %v over %d", drawdown, duration)
+	}
+}
+
+func TestMarkdownHasNoTables(t *testing.T) {
+	markdown := GenerateReport("fixture", "BTC-USD", reportFixture()).Markdown()
+	if strings.Contains(markdown, "|") {
+		t.Fatalf("Discord does not render tables:\n%s", markdown)
+	}
+	if !strings.Contains(markdown, "**Return:** 18.80%\n") || !strings.Contains(markdown, "**Trades:** 6 (66.7% wins)\n") {
+		t.Fatalf("missing metrics:\n%s", markdown)
+	}
+}
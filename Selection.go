@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+// Picks parents for the next generation, the scores are never modified
+type Selector interface {
+	Select(rng *rand.Rand, botScores []BotGenerationScore, count int) []NeuralNet
+}
+
+// Parents are drawn uniformly from the best 'keep' bots
+type truncationSelector struct {
+	keep int
+}
+
+// Parents are the best of 'size' randomly drawn bots
+type tournamentSelector struct {
+	size int
+}
+
+// Parents are drawn with a chance proportional to their score
+type rouletteSelector struct{}
+
+// Parents are drawn with a chance based on their rank, pressure (1 - 2) controls how much the best are favoured
+type rankSelector struct {
+	pressure float64
+}
+
+// Create the configured selector
+func getSelector(settings TrainingSettings) (Selector, error) {
+	switch settings.Selection {
+	case "truncation":
+		keep := int(float64(settings.PopulationSize) * settings.TruncationFraction)
+		if keep < 1 {
+			keep = 1
+		}
+		return truncationSelector{keep: keep}, nil
+	case "tournament":
+		if settings.TournamentSize < 1 {
+			return nil, errors.New("tournament_size must be at least 1")
+		}
+		return tournamentSelector{size: settings.TournamentSize}, nil
+	case "roulette":
+		return rouletteSelector{}, nil
+	case "rank":
+		if settings.RankPressure < 1 || settings.RankPressure > 2 {
+			return nil, errors.New("rank_pressure must be between 1 and 2")
+		}
+		return rankSelector{pressure: settings.RankPressure}, nil
+	}
+	return nil, errors.New("unknown selection '" + settings.Selection + "'")
+}
+
+// Indexes of the scores, best first
+func rankedIndexes(botScores []BotGenerationScore) []int {
+	order := make([]int, len(botScores))
+	for index := range order {
+		order[index] = index
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return botScores[order[i]].score > botScores[order[j]].score
+	})
+	return order
+}
+
+func (selector truncationSelector) Select(rng *rand.Rand, botScores []BotGenerationScore, count int) []NeuralNet {
+	top := getTop(botScores, selector.keep)
+	parents := make([]NeuralNet, count)
+	for index := range parents {
+		parents[index] = top[rng.Intn(len(top))]
+	}
+	return parents
+}
+
+func (selector tournamentSelector) Select(rng *rand.Rand, botScores []BotGenerationScore, count int) []NeuralNet {
+	parents := make([]NeuralNet, count)
+	for index := range parents {
+		best := rng.Intn(len(botScores))
+		for round := 1; round < selector.size; round++ {
+			challenger := rng.Intn(len(botScores))
+			if botScores[challenger].score > botScores[best].score {
+				best = challenger
+			}
+		}
+		parents[index] = botScores[best].Bot
+	}
+	return parents
+}
+
+// Draw an index with a chance proportional to its weight
+func spinWheel(rng *rand.Rand, weights []float64, total float64) int {
+	spin := rng.Float64() * total
+	for index, weight := range weights {
+		spin -= weight
+		if spin < 0 {
+			return index
+		}
+	}
+	return len(weights) - 1
+}
+
+func (rouletteSelector) Select(rng *rand.Rand, botScores []BotGenerationScore, count int) []NeuralNet {
+	// Scores can be negative, so shift them so the worst bot still has a small chance
+	lowest := botScores[0].score
+	highest := botScores[0].score
+	for _, botScore := range botScores {
+		if botScore.score < lowest {
+			lowest = botScore.score
+		}
+		if botScore.score > highest {
+			highest = botScore.score
+		}
+	}
+	floor := (highest - lowest) / float64(len(botScores))
+	if floor == 0 {
+		floor = 1
+	}
+	weights := make([]float64, len(botScores))
+	total := 0.0
+	for index, botScore := range botScores {
+		weights[index] = botScore.score - lowest + floor
+		total += weights[index]
+	}
+	parents := make([]NeuralNet, count)
+	for index := range parents {
+		parents[index] = botScores[spinWheel(rng, weights, total)].Bot
+	}
+	return parents
+}
+
+func (selector rankSelector) Select(rng *rand.Rand, botScores []BotGenerationScore, count int) []NeuralNet {
+	order := rankedIndexes(botScores)
+	size := float64(len(order))
+	weights := make([]float64, len(order))
+	total := 0.0
+	for rank := range order {
+		// Linear ranking, the best bot (rank 0) gets 'pressure' times the average chance
+		weights[rank] = selector.pressure
+		if size > 1 {
+			weights[rank] = selector.pressure - 2*(selector.pressure-1)*float64(rank)/(size-1)
+		}
+		total += weights[rank]
+	}
+	parents := make([]NeuralNet, count)
+	for index := range parents {
+		parents[index] = botScores[order[spinWheel(rng, weights, total)]].Bot
+	}
+	return parents
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Most recent candles given to a sizer
+const sizingCandles = 250
+
+// Account state an order is sized against
+type SizingContext struct {
+	Side           string  // buy or sell
+	Price          float64 // Limit price of the order
+	QuoteAvailable float64
+	BaseAvailable  float64
+	Equity         float64           // Quote value of the whole account at the latest price
+	Candles        []HistoricalEntry // Recent candles sorted oldest first, used by volatility sizing
+}
+
+// Decides how much base currency an order is for, configured by AmountCalculationType and AmountData
+type PositionSizer interface {
+	// Identifies the sizer and its parameters
+	Name() string
+	// Amount of base currency to order, before rounding to the product
+	Size(context SizingContext) (float64, error)
+}
+
+// Fixed amount of quote currency per order
+type fixedQuoteSizer struct {
+	amount float64
+}
+
+// Fixed amount of base currency per order
+type fixedBaseSizer struct {
+	amount float64
+}
+
+// Percent of the available quote when buying, of the available base when selling
+type percentBalanceSizer struct {
+	percent float64
+}
+
+// Percent of the account value per order
+type percentEquitySizer struct {
+	percent float64
+}
+
+// Risk a percent of the account value on a move of 'multiple' average true ranges
+type atrSizer struct {
+	risk     float64 // Percent of equity
+	multiple float64
+	period   int
+}
+
+// Fraction of the Kelly bet for the given win rate and average win / loss ratio
+type kellySizer struct {
+	winRate  float64
+	payoff   float64
+	fraction float64
+}
+
+// Create the sizer of the bot, AmountData holds its parameters separated by ':'
+func getPositionSizer(settings BotSettings) (PositionSizer, error) {
+	params, err := sizingParams(settings.AmountData)
+	if err != nil {
+		return nil, err
+	}
+	switch settings.AmountCalculationType {
+	case "fixed_quote", "SetCurrency": // SetCurrency from before sizers existed
+		if len(params) != 1 || params[0] <= 0 {
+			return nil, errors.New(settings.AmountCalculationType + " needs a positive quote amount, got '" + settings.AmountData + "'")
+		}
+		return fixedQuoteSizer{amount: params[0]}, nil
+	case "fixed_base":
+		if len(params) != 1 || params[0] <= 0 {
+			return nil, errors.New("fixed_base needs a positive base amount, got '" + settings.AmountData + "'")
+		}
+		return fixedBaseSizer{amount: params[0]}, nil
+	case "percent_balance":
+		if len(params) != 1 || params[0] <= 0 || params[0] > 100 {
+			return nil, errors.New("percent_balance needs a percent between 0 and 100, got '" + settings.AmountData + "'")
+		}
+		return percentBalanceSizer{percent: params[0]}, nil
+	case "percent_equity":
+		if len(params) != 1 || params[0] <= 0 || params[0] > 100 {
+			return nil, errors.New("percent_equity needs a percent between 0 and 100, got '" + settings.AmountData + "'")
+		}
+		return percentEquitySizer{percent: params[0]}, nil
+	case "atr":
+		if len(params) < 2 || len(params) > 3 || params[0] <= 0 || params[0] > 100 || params[1] <= 0 {
+			return nil, errors.New("atr needs 'risk_percent:atr_multiple[:period]' with a risk between 0 and 100, got '" + settings.AmountData + "'")
+		}
+		sizer := atrSizer{risk: params[0], multiple: params[1], period: 14}
+		if len(params) == 3 {
+			if params[2] < 1 || params[2] >= sizingCandles || params[2] != math.Trunc(params[2]) {
+				return nil, fmt.Errorf("atr period must be a whole number between 1 and %d, got '%s'", sizingCandles-1, settings.AmountData)
+			}
+			sizer.period = int(params[2])
+		}
+		return sizer, nil
+	case "kelly":
+		if len(params) < 2 || len(params) > 3 || params[0] <= 0 || params[0] >= 1 || params[1] <= 0 {
+			return nil, errors.New("kelly needs 'win_rate:payoff[:fraction]' with a win rate between 0 and 1, got '" + settings.AmountData + "'")
+		}
+		sizer := kellySizer{winRate: params[0], payoff: params[1], fraction: 1}
+		if len(params) == 3 {
+			if params[2] <= 0 || params[2] > 1 {
+				return nil, errors.New("kelly fraction must be between 0 and 1, got '" + settings.AmountData + "'")
+			}
+			sizer.fraction = params[2]
+		}
+		return sizer, nil
+	}
+	return nil, errors.New("unknown amount calculation '" + settings.AmountCalculationType + "'")
+}
+
+func sizingParams(data string) ([]float64, error) {
+	parts := strings.Split(data, ":")
+	params := make([]float64, len(parts))
+	for index, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, errors.New("amount data '" + data + "' has an invalid number '" + part + "'")
+		}
+		params[index] = value
+	}
+	return params, nil
+}
+
+func (sizer fixedQuoteSizer) Name() string { return fmt.Sprintf("fixed_quote:%g", sizer.amount) }
+
+func (sizer fixedQuoteSizer) Size(context SizingContext) (float64, error) {
+	if context.Price <= 0 {
+		return 0, errors.New("order price must be above 0")
+	}
+	return sizer.amount / context.Price, nil
+}
+
+func (sizer fixedBaseSizer) Name() string { return fmt.Sprintf("fixed_base:%g", sizer.amount) }
+
+func (sizer fixedBaseSizer) Size(context SizingContext) (float64, error) {
+	return sizer.amount, nil
+}
+
+func (sizer percentBalanceSizer) Name() string {
+	return fmt.Sprintf("percent_balance:%g", sizer.percent)
+}
+
+func (sizer percentBalanceSizer) Size(context SizingContext) (float64, error) {
+	if context.Side == "sell" {
+		return context.BaseAvailable * sizer.percent / 100, nil
+	}
+	if context.Price <= 0 {
+		return 0, errors.New("order price must be above 0")
+	}
+	// Leave room for the fee so the whole balance can be spent
+	fee, _ := feePerc.Float64()
+	return context.QuoteAvailable * sizer.percent / 100 / (context.Price * (1 + fee)), nil
+}
+
+func (sizer percentEquitySizer) Name() string { return fmt.Sprintf("percent_equity:%g", sizer.percent) }
+
+func (sizer percentEquitySizer) Size(context SizingContext) (float64, error) {
+	if context.Price <= 0 {
+		return 0, errors.New("order price must be above 0")
+	}
+	return context.Equity * sizer.percent / 100 / context.Price, nil
+}
+
+func (sizer atrSizer) Name() string {
+	return fmt.Sprintf("atr:%g:%g:%d", sizer.risk, sizer.multiple, sizer.period)
+}
+
+// The loss of a move of 'multiple' ATRs against the position equals 'risk' percent of the equity
+func (sizer atrSizer) Size(context SizingContext) (float64, error) {
+	atr, ok := averageTrueRange(context.Candles, sizer.period)
+	if !ok {
+		return 0, fmt.Errorf("atr sizing needs %d candles, got %d", sizer.period+1, len(context.Candles))
+	}
+	if atr <= 0 {
+		return 0, errors.New("average true range is 0, the market did not move")
+	}
+	return context.Equity * sizer.risk / 100 / (atr * sizer.multiple), nil
+}
+
+func (sizer kellySizer) Name() string {
+	return fmt.Sprintf("kelly:%g:%g:%g", sizer.winRate, sizer.payoff, sizer.fraction)
+}
+
+// Kelly bet of p - (1 - p) / b of the equity, nothing when the edge is negative
+func (sizer kellySizer) Size(context SizingContext) (float64, error) {
+	if context.Price <= 0 {
+		return 0, errors.New("order price must be above 0")
+	}
+	kelly := sizer.winRate - (1-sizer.winRate)/sizer.payoff
+	if kelly <= 0 {
+		return 0, nil
+	}
+	return context.Equity * kelly * sizer.fraction / context.Price, nil
+}
+
+// Wilder average of the true range over the last 'period' candles, false when there are not enough candles
+func averageTrueRange(candles []HistoricalEntry, period int) (float64, bool) {
+	if len(candles) < period+1 {
+		return 0, false
+	}
+	average := 0.0
+	for index := 1; index < len(candles); index++ {
+		candle := candles[index]
+		previous := candles[index-1].lastTradePrice
+		trueRange := math.Max(candle.highestPrice-candle.lowestPrice, math.Max(math.Abs(candle.highestPrice-previous), math.Abs(candle.lowestPrice-previous)))
+		if index <= period {
+			average += trueRange / float64(period)
+		} else {
+			average = (average*float64(period-1) + trueRange) / float64(period)
+		}
+	}
+	return average, true
+}
+
+// Round a size down to the products size increment, 0 when it is below the minimum size. The minimum
+// size doubles as the increment as the products do not list one.
+func roundSize(size float64, product Product) decimal.Decimal {
+	if size <= 0 || math.IsNaN(size) || math.IsInf(size, 0) {
+		return decimal.Zero
+	}
+	rounded := decimal.NewFromFloat(size).Truncate(int32(decimalPlaces(product.BaseMinSize)))
+	if product.BaseMaxSize.IsPositive() && rounded.GreaterThan(product.BaseMaxSize) {
+		rounded = product.BaseMaxSize
+	}
+	if rounded.LessThan(product.BaseMinSize) || !rounded.IsPositive() {
+		return decimal.Zero
+	}
+	return rounded
+}
+
+// Size an order, clamp it to the available balance and round it to the product. Only the last
+// sizingCandles candles are given to the sizer.
+func sizeOrder(sizer PositionSizer, context SizingContext, product Product) (decimal.Decimal, error) {
+	if len(context.Candles) > sizingCandles {
+		context.Candles = context.Candles[len(context.Candles)-sizingCandles:]
+	}
+	size, err := sizer.Size(context)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return roundSize(clampSize(size, context), product), nil
+}
+
+// Limit a size to what the account can pay for, quote including the fee when buying and base when selling
+func clampSize(size float64, context SizingContext) float64 {
+	available := context.BaseAvailable
+	if context.Side == "buy" {
+		if context.Price <= 0 {
+			return 0
+		}
+		fee, _ := feePerc.Float64()
+		available = context.QuoteAvailable / (context.Price * (1 + fee))
+	}
+	return math.Min(size, math.Max(available, 0))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"github.com/shopspring/decimal"
+	"math"
+	"testing"
+)
+
+func TestRoundSize(t *testing.T) {
+	product := Product{BaseMinSize: decimal.RequireFromString("0.001"), BaseMaxSize: decimal.RequireFromString("2")}
+	unlimited := Product{BaseMinSize: decimal.RequireFromString("0.01")}
+	tests := []struct {
+		name     string
+		size     float64
+		product  Product
+		expected string
+	}{
+		{"truncated to the increment", 0.123456, product, "0.123"},
+		{"never rounded up", 0.0019999, product, "0.001"},
+		{"exact increment kept", 0.5, product, "0.5"},
+		{"below the minimum", 0.000999, product, "0"},
+		{"capped at the maximum", 2.5, product, "2"},
+		{"maximum kept", 2, product, "2"},
+		{"no maximum", 1234.5678, unlimited, "1234.56"},
+		{"zero", 0, product, "0"},
+		{"negative", -1, product, "0"},
+		{"not a number", math.NaN(), product, "0"},
+		{"infinite", math.Inf(1), product, "0"},
+	}
+	for _, test := range tests {
+		if rounded := roundSize(test.size, test.product); !rounded.Equal(decimal.RequireFromString(test.expected)) {
+			t.Errorf("%s: roundSize(%v) = %s, expected %s", test.name, test.size, rounded, test.expected)
+		}
+	}
+}
+
+func TestInvalidPositionSizer(t *testing.T) {
+	tests := []struct {
+		calculation string
+		data        string
+	}{
+		{"fixed_quote", "0"},
+		{"fixed_quote", "-5"},
+		{"fixed_quote", "5:1"},
+		{"SetCurrency", "abc"},
+		{"fixed_base", ""},
+		{"percent_balance", "0"},
+		{"percent_balance", "101"},
+		{"percent_equity", "NaN"},
+		{"atr", "1"},
+		{"atr", "0:2"},
+		{"atr", "1:0"},
+		{"atr", "1:2:0"},
+		{"atr", "1:2:2.5"},
+		{"atr", "1:2:250"},
+		{"atr", "1:2:14:1"},
+		{"kelly", "1:1.5"},
+		{"kelly", "0.6:0"},
+		{"kelly", "0.6:1.5:0"},
+		{"kelly", "0.6:1.5:1.5"},
+		{"martingale", "1"},
+	}
+	for _, test := range tests {
+		settings := BotSettings{AmountCalculationType: test.calculation, AmountData: test.data}
+		if sizer, err := getPositionSizer(settings); err == nil {
+			t.Errorf("%s '%s' was accepted as %s", test.calculation, test.data, sizer.Name())
+		}
+	}
+}
+
+func TestSizeOrderClampsToBalance(t *testing.T) {
+	product := Product{BaseMinSize: decimal.RequireFromString("0.001")}
+	tests := []struct {
+		name     string
+		sizer    PositionSizer
+		context  SizingContext
+		expected string
+	}{
+		{"buy within the balance", fixedBaseSizer{amount: 1}, SizingContext{Side: "buy", Price: 100, QuoteAvailable: 500}, "1"},
+		{"buy limited by the quote and fee", fixedBaseSizer{amount: 10}, SizingContext{Side: "buy", Price: 100, QuoteAvailable: 250}, "2.487"}, // 250 / (100 * 1.005)
+		{"buy without quote", fixedQuoteSizer{amount: 50}, SizingContext{Side: "buy", Price: 100}, "0"},
+		{"buy below the minimum after clamping", fixedBaseSizer{amount: 1}, SizingContext{Side: "buy", Price: 100, QuoteAvailable: 0.05}, "0"},
+		{"sell within the balance", fixedBaseSizer{amount: 1}, SizingContext{Side: "sell", Price: 100, BaseAvailable: 3}, "1"},
+		{"sell limited by the base", percentEquitySizer{percent: 100}, SizingContext{Side: "sell", Price: 100, BaseAvailable: 0.75, Equity: 1000}, "0.75"},
+		{"sell ignores the quote", fixedBaseSizer{amount: 1}, SizingContext{Side: "sell", Price: 100, QuoteAvailable: 1000}, "0"},
+		{"negative balance", fixedBaseSizer{amount: 1}, SizingContext{Side: "sell", Price: 100, BaseAvailable: -1}, "0"},
+	}
+	for _, test := range tests {
+		size, err := sizeOrder(test.sizer, test.context, product)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+		} else if !size.Equal(decimal.RequireFromString(test.expected)) {
+			t.Errorf("%s: sized %s, expected %s", test.name, size, test.expected)
+		}
+	}
+}
+
+// Candles closing at 100 whose true ranges are the given values, after a first candle only used as the previous close
+func rangeCandles(ranges ...float64) []HistoricalEntry {
+	candles := []HistoricalEntry{{lowestPrice: 100, highestPrice: 100, firstTradePrice: 100, lastTradePrice: 100}}
+	for _, spread := range ranges {
+		candles = append(candles, HistoricalEntry{lowestPrice: 100 - spread/2, highestPrice: 100 + spread/2, firstTradePrice: 100, lastTradePrice: 100})
+	}
+	return candles
+}
+
+func TestSizerMath(t *testing.T) {
+	tests := []struct {
+		name     string
+		sizer    PositionSizer
+		context  SizingContext
+		expected float64
+	}{
+		{"fixed quote", fixedQuoteSizer{amount: 50}, SizingContext{Side: "buy", Price: 200}, 0.25},
+		{"fixed base", fixedBaseSizer{amount: 0.3}, SizingContext{Side: "sell", Price: 200}, 0.3},
+		{"percent balance buy leaves the fee", percentBalanceSizer{percent: 50}, SizingContext{Side: "buy", Price: 100, QuoteAvailable: 1000}, 500 / (100 * 1.005)},
+		{"percent balance sell", percentBalanceSizer{percent: 25}, SizingContext{Side: "sell", Price: 100, BaseAvailable: 2}, 0.5},
+		{"percent equity", percentEquitySizer{percent: 10}, SizingContext{Side: "buy", Price: 200, Equity: 1000}, 0.5},
+		{"percent equity sell", percentEquitySizer{percent: 10}, SizingContext{Side: "sell", Price: 200, Equity: 1000}, 0.5},
+		// 1% of 1000 risked on a move of 2 ATRs of 2
+		{"atr risk", atrSizer{risk: 1, multiple: 2, period: 3}, SizingContext{Side: "buy", Price: 100, Equity: 1000, Candles: rangeCandles(2, 2, 2)}, 2.5},
+		// Wilder average: (2 + 4) / 2 = 3, then (3 * 1 + 6) / 2 = 4.5, so 1% of 900 over 4.5
+		{"atr risk smoothed", atrSizer{risk: 1, multiple: 1, period: 2}, SizingContext{Side: "sell", Price: 100, Equity: 900, Candles: rangeCandles(2, 4, 6)}, 2},
+		// 0.6 - 0.4 / 1.5 = 1/3 of the equity
+		{"kelly", kellySizer{winRate: 0.6, payoff: 1.5, fraction: 1}, SizingContext{Side: "buy", Price: 100, Equity: 1000}, 1000.0 / 3 / 100},
+		{"half kelly", kellySizer{winRate: 0.6, payoff: 1.5, fraction: 0.5}, SizingContext{Side: "buy", Price: 100, Equity: 1000}, 1000.0 / 6 / 100},
+		{"kelly without an edge", kellySizer{winRate: 0.3, payoff: 1, fraction: 1}, SizingContext{Side: "buy", Price: 100, Equity: 1000}, 0},
+	}
+	for _, test := range tests {
+		size, err := test.sizer.Size(test.context)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+		} else if math.Abs(size-test.expected) > 1e-9 {
+			t.Errorf("%s: sized %v, expected %v", test.name, size, test.expected)
+		}
+	}
+}
+
+func TestSizerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		sizer   PositionSizer
+		context SizingContext
+	}{
+		{"fixed quote without a price", fixedQuoteSizer{amount: 50}, SizingContext{Side: "buy"}},
+		{"percent equity without a price", percentEquitySizer{percent: 10}, SizingContext{Side: "buy", Equity: 1000}},
+		{"atr without enough candles", atrSizer{risk: 1, multiple: 2, period: 3}, SizingContext{Side: "buy", Price: 100, Equity: 1000, Candles: rangeCandles(2, 2)}},
+		{"atr on a flat market", atrSizer{risk: 1, multiple: 2, period: 2}, SizingContext{Side: "buy", Price: 100, Equity: 1000, Candles: rangeCandles(0, 0)}},
+		{"kelly without a price", kellySizer{winRate: 0.6, payoff: 1.5, fraction: 1}, SizingContext{Side: "buy", Equity: 1000}},
+	}
+	for _, test := range tests {
+		if size, err := test.sizer.Size(test.context); err == nil {
+			t.Errorf("%s: expected an error, sized %v", test.name, size)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	. "fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/shopspring/decimal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Candles the exchange sends per request
+const candlesPerRequest = 300
+
+// Candles the features are computed over each update, enough for the indicators and normalizer to warm up
+const liveCandles = 600
+
+// Saved model the live loop trades with, together with the state it keeps between updates
+type liveModel struct {
+	net      NeuralNet
+	metadata ModelMetadata
+	pipeline FeaturePipeline
+	last     int64 // Timestamp of the newest candle fed to the net, the recurrent state covers every candle up to it
+}
+
+// Load the saved model of the bot, rebuilding the features it was trained on
+func loadLiveModel(name string) (liveModel, error) {
+	net, metadata, err := LoadNet(modelPath(name))
+	if err != nil {
+		return liveModel{}, err
+	}
+	pipeline, err := newFeaturePipeline(metadata.FeatureSet, metadata.Lookback)
+	if err != nil {
+		return liveModel{}, err
+	}
+	if pipeline.Width() != netTopology(net).InputSize {
+		return liveModel{}, errors.New("model features do not match its input size")
+	}
+	pipeline.Normalizer = metadata.Normalizer
+	return liveModel{net: withFreshState(net), metadata: metadata, pipeline: pipeline}, nil
+}
+
+// Place orders with the bots saved model every UpdateTime seconds, picking up newly saved models as training improves them
+func trade(exchange Exchange, settings BotSettings, discord *discordgo.Session) {
+	if settings.UpdateTime < 1 {
+		BotLog(discord, settings.Name+" refusing to trade, the update time must be at least 1 second")
+		return
+	}
+	sizer, err := getPositionSizer(settings)
+	if err != nil {
+		BotLog(discord, settings.Name+" refusing to trade, "+err.Error())
+		return
+	}
+	var model liveModel
+	ticker := time.NewTicker(time.Duration(settings.UpdateTime) * time.Second)
+	defer ticker.Stop()
+	for {
+		saved, err := loadLiveModel(settings.Name)
+		if err != nil {
+			Println(settings.Name + " has no model to trade with yet, " + err.Error())
+		} else {
+			if saved.metadata.SavedAt != model.metadata.SavedAt {
+				BotLog(discord, settings.Name+" trading with the model from generation "+strconv.Itoa(saved.metadata.Generation))
+				model = saved
+			}
+			if err := tradeUpdate(exchange, settings, sizer, &model); err != nil {
+				Println(settings.Name + " failed to update, " + err.Error())
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Feed the candles since the last update through the net and act on its newest output
+func tradeUpdate(exchange Exchange, settings BotSettings, sizer PositionSizer, model *liveModel) error {
+	ticker, err := exchange.Ticker(settings.Market)
+	if err != nil {
+		return err
+	}
+	now := ticker.Time
+	if now == 0 {
+		now = time.Now().Unix()
+	}
+	candles, err := recentCandles(exchange, settings.Market, now, liveCandles)
+	if err != nil {
+		return err
+	}
+	if len(candles) == 0 {
+		return errors.New("no recent candles for " + settings.Market)
+	}
+	inputs := model.pipeline.Transform(candles)
+	var output []float64
+	for index, candle := range candles {
+		if candle.timestamp > model.last {
+			output = Compute(inputs[index], model.net)
+			model.last = candle.timestamp
+		}
+	}
+	if output == nil { // No new candle since the last update
+		return nil
+	}
+	side := ""
+	margin := 0.0
+	switch netAction(output) {
+	case 1:
+		side = "buy"
+		margin = -settings.MarginBuy
+	case 2:
+		side = "sell"
+		margin = settings.MarginSell
+	default:
+		return nil
+	}
+	mid := GetMidMarket(exchange, settings.Market)
+	if !mid.IsPositive() {
+		return errors.New("no mid market price for " + settings.Market)
+	}
+	product, err := exchange.Product(settings.Market)
+	if err != nil {
+		return err
+	}
+	price := mid.Mul(decimal.NewFromFloat(1 + margin)).Round(int32(decimalPlaces(product.QuoteIncrement)))
+	balances, err := exchange.Balances()
+	if err != nil {
+		return err
+	}
+	context := SizingContext{Side: side, Candles: candles}
+	context.Price, _ = price.Float64()
+	midPrice, _ := mid.Float64()
+	for _, balance := range balances {
+		available, _ := balance.Available.Float64()
+		total, _ := balance.Balance.Float64()
+		if strings.EqualFold(balance.Currency, product.QuoteCurrency) {
+			context.QuoteAvailable = available
+			context.Equity += total
+		} else if strings.EqualFold(balance.Currency, product.BaseCurrency) {
+			context.BaseAvailable = available
+			context.Equity += total * midPrice
+		}
+	}
+	size, err := sizeOrder(sizer, context, product)
+	if err != nil {
+		return err
+	}
+	if size.IsZero() {
+		return errors.New("skipping " + side + " order, its size within the available balance is below the minimum of " + product.BaseMinSize.String())
+	}
+	PlaceOrder(exchange, side, settings.Market, size, price)
+	return nil
+}
+
+// The last 'count' one minute candles up to end, fetched in as many requests as the exchange needs
+func recentCandles(exchange Exchange, market string, end int64, count int) ([]HistoricalEntry, error) {
+	candles := make([]HistoricalEntry, 0, count)
+	start := end - int64(count)*60
+	for from := start; from < end; from += candlesPerRequest * 60 {
+		to := from + (candlesPerRequest-1)*60
+		if to > end {
+			to = end
+		}
+		chunk, err := exchange.Candles(market, from, to)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, chunk...)
+	}
+	return candles, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"math/rand"
+)
+
+var trainingConfig viper.Viper
+
+type TrainingSettings struct {
+	Features  []string // Feature specs, see featureConstructors
+	Lookback  int      // Candles of features stacked into each input
+	InputSize int      // Width of the feature pipeline
+	// Fitted on the history the window strategy can score on from the cursor, and refitted whenever the cursor
+	// moves: the window at the cursor for fixed and sequential, every window that can be sampled for random and
+	// regime (up to the cursor with walk-forward, so the validation window is never seen). rolling_zscore needs
+	// no fit and scales each row by the rows before it.
+	Normalization          string
+	NormalizationWindow    int    // Rows used by rolling_zscore
+	Recurrent              string // none, rnn or gru
+	RecurrentSize          int
+	HiddenLayers           []int
+	OutputSize             int
+	HiddenActivation       Activation
+	OutputActivation       Activation
+	PopulationSize         int
+	EliteFraction          float64
+	ImmigrantFraction      float64
+	MutationMin            int
+	MutationMax            int // Exclusive
+	Selection              string
+	TruncationFraction     float64
+	TournamentSize         int
+	RankPressure           float64
+	Crossover              string
+	CrossoverRate          float64 // Chance a new bot is bred from two parents instead of copied from one
+	GenerationWindow       int64   // Seconds of history each generation is scored on
+	WalkForward            bool    // Validate on the window after the training window and roll forward
+	WalkForwardGenerations int     // Generations trained on a window before rolling forward
+	WindowStrategy         string
+	WindowStep             int64   // Seconds the sequential strategy moves forward each generation
+	WindowSamples          int     // Windows drawn each generation by the random and regime strategies
+	RegimeThreshold        float64 // Return a window needs to count as a bull or bear market
+	Float32                bool    // Evaluate bots in single precision
+	Workers                int     // Bots evaluated at once, 0 uses every core
+	Seed                   int64   // 0 picks a seed from the clock
+	Labeler                string
+	LabelHorizon           int     // Candles looked ahead by forward_return and triple_barrier
+	LabelThreshold         float64 // Move needed by forward_return and zigzag
+	LabelTakeProfit        float64
+	LabelStopLoss          float64
+	Fitness                string
+	FitnessWeights         map[string]float64 // Only used by weighted fitness
+	DrawdownPenalty        float64
+	Backprop               TrainerConfig
+}
+
+func readTrainingConfig() viper.Viper {
+	trainingConfig := viper.New()
+	trainingConfig.SetConfigName("training")
+	trainingConfig.SetConfigType("json")
+	trainingConfig.AddConfigPath(BaseDir)
+	setTrainingDefaults(trainingConfig)
+	// Read config
+	if err := trainingConfig.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			trainingConfig.SafeWriteConfig()
+		} else {
+			panic(err)
+		}
+	}
+	return *trainingConfig
+}
+
+func setTrainingDefaults(trainingConfig *viper.Viper) {
+	trainingConfig.SetDefault("features", []string{"log_returns", "returns:15", "sma_ratio:20", "ema_ratio:50", "rsi:14", "macd:12:26:9", "bollinger_b:20:2", "atr:14", "vwap_distance:60", "volume_zscore:60", "time_of_day", "day_of_week"})
+	trainingConfig.SetDefault("lookback", 1)
+	trainingConfig.SetDefault("normalization", "zscore")
+	trainingConfig.SetDefault("normalization_window", 120)
+	trainingConfig.SetDefault("recurrent", "none")
+	trainingConfig.SetDefault("recurrent_size", 8)
+	trainingConfig.SetDefault("hidden_layers", []int{12, 12, 12})
+	trainingConfig.SetDefault("output_size", 3)
+	trainingConfig.SetDefault("hidden_activation", string(ActivationSigmoid))
+	trainingConfig.SetDefault("output_activation", string(ActivationSoftmax))
+	trainingConfig.SetDefault("population_size", 100)
+	trainingConfig.SetDefault("elite_fraction", 0.1)
+	trainingConfig.SetDefault("immigrant_fraction", 0.1)
+	trainingConfig.SetDefault("mutation_min", 10)
+	trainingConfig.SetDefault("mutation_max", 40)
+	trainingConfig.SetDefault("selection", "truncation")
+	trainingConfig.SetDefault("truncation_fraction", 0.1)
+	trainingConfig.SetDefault("tournament_size", 3)
+	trainingConfig.SetDefault("rank_pressure", 1.5)
+	trainingConfig.SetDefault("crossover", "none")
+	trainingConfig.SetDefault("crossover_rate", 0.5)
+	trainingConfig.SetDefault("generation_window_hours", 60)
+	trainingConfig.SetDefault("walk_forward", true)
+	trainingConfig.SetDefault("walk_forward_generations", 50)
+	trainingConfig.SetDefault("window_strategy", "fixed")
+	trainingConfig.SetDefault("window_step_hours", 6)
+	trainingConfig.SetDefault("window_samples", 3)
+	trainingConfig.SetDefault("regime_threshold", 0.02)
+	trainingConfig.SetDefault("float32", false)
+	trainingConfig.SetDefault("workers", 0)
+	trainingConfig.SetDefault("seed", 0)
+	trainingConfig.SetDefault("labeler", "rank")
+	trainingConfig.SetDefault("label_horizon", 60)
+	trainingConfig.SetDefault("label_threshold", 0.01)
+	trainingConfig.SetDefault("label_take_profit", 0.02)
+	trainingConfig.SetDefault("label_stop_loss", 0.01)
+	trainingConfig.SetDefault("fitness", "label")
+	trainingConfig.SetDefault("fitness_weights", map[string]interface{}{"label": 0.5, "pnl": 0.5})
+	trainingConfig.SetDefault("fitness_drawdown_penalty", 1.0)
+	trainingConfig.SetDefault("backprop.epochs", 50)
+	trainingConfig.SetDefault("backprop.batch_size", 32)
+	trainingConfig.SetDefault("backprop.learning_rate", 0.01)
+	trainingConfig.SetDefault("backprop.loss", "cross_entropy")
+	trainingConfig.SetDefault("backprop.optimizer", "adam")
+	trainingConfig.SetDefault("backprop.momentum", 0.9)
+	trainingConfig.SetDefault("backprop.schedule", "constant")
+	trainingConfig.SetDefault("backprop.decay_rate", 0.5)
+	trainingConfig.SetDefault("backprop.decay_steps", 10)
+}
+
+// Read and validate the training settings
+func loadTrainingSettings() (TrainingSettings, error) {
+	trainingConfig = readTrainingConfig()
+	settings := trainingSettingsFrom(trainingConfig)
+	return settings, validateTrainingSettings(settings)
+}
+
+// Settings used when nothing is configured, without touching training.json
+func defaultTrainingSettings() TrainingSettings {
+	defaults := viper.New()
+	setTrainingDefaults(defaults)
+	return trainingSettingsFrom(*defaults)
+}
+
+func trainingSettingsFrom(trainingConfig viper.Viper) TrainingSettings {
+	settings := TrainingSettings{
+		Features:               trainingConfig.GetStringSlice("features"),
+		Lookback:               trainingConfig.GetInt("lookback"),
+		Normalization:          trainingConfig.GetString("normalization"),
+		NormalizationWindow:    trainingConfig.GetInt("normalization_window"),
+		Recurrent:              trainingConfig.GetString("recurrent"),
+		RecurrentSize:          trainingConfig.GetInt("recurrent_size"),
+		HiddenLayers:           trainingConfig.GetIntSlice("hidden_layers"),
+		OutputSize:             trainingConfig.GetInt("output_size"),
+		HiddenActivation:       Activation(trainingConfig.GetString("hidden_activation")),
+		OutputActivation:       Activation(trainingConfig.GetString("output_activation")),
+		PopulationSize:         trainingConfig.GetInt("population_size"),
+		EliteFraction:          trainingConfig.GetFloat64("elite_fraction"),
+		ImmigrantFraction:      trainingConfig.GetFloat64("immigrant_fraction"),
+		MutationMin:            trainingConfig.GetInt("mutation_min"),
+		MutationMax:            trainingConfig.GetInt("mutation_max"),
+		Selection:              trainingConfig.GetString("selection"),
+		TruncationFraction:     trainingConfig.GetFloat64("truncation_fraction"),
+		TournamentSize:         trainingConfig.GetInt("tournament_size"),
+		RankPressure:           trainingConfig.GetFloat64("rank_pressure"),
+		Crossover:              trainingConfig.GetString("crossover"),
+		CrossoverRate:          trainingConfig.GetFloat64("crossover_rate"),
+		GenerationWindow:       trainingConfig.GetInt64("generation_window_hours") * 60 * 60,
+		WalkForward:            trainingConfig.GetBool("walk_forward"),
+		WalkForwardGenerations: trainingConfig.GetInt("walk_forward_generations"),
+		WindowStrategy:         trainingConfig.GetString("window_strategy"),
+		WindowStep:             trainingConfig.GetInt64("window_step_hours") * 60 * 60,
+		WindowSamples:          trainingConfig.GetInt("window_samples"),
+		RegimeThreshold:        trainingConfig.GetFloat64("regime_threshold"),
+		Float32:                trainingConfig.GetBool("float32"),
+		Workers:                trainingConfig.GetInt("workers"),
+		Seed:                   trainingConfig.GetInt64("seed"),
+		Labeler:                trainingConfig.GetString("labeler"),
+		LabelHorizon:           trainingConfig.GetInt("label_horizon"),
+		LabelThreshold:         trainingConfig.GetFloat64("label_threshold"),
+		LabelTakeProfit:        trainingConfig.GetFloat64("label_take_profit"),
+		LabelStopLoss:          trainingConfig.GetFloat64("label_stop_loss"),
+		Fitness:                trainingConfig.GetString("fitness"),
+		FitnessWeights:         readWeights(trainingConfig.GetStringMap("fitness_weights")),
+		DrawdownPenalty:        trainingConfig.GetFloat64("fitness_drawdown_penalty"),
+		Backprop: TrainerConfig{
+			Epochs:       trainingConfig.GetInt("backprop.epochs"),
+			BatchSize:    trainingConfig.GetInt("backprop.batch_size"),
+			LearningRate: trainingConfig.GetFloat64("backprop.learning_rate"),
+			Loss:         trainingConfig.GetString("backprop.loss"),
+			Optimizer:    trainingConfig.GetString("backprop.optimizer"),
+			Momentum:     trainingConfig.GetFloat64("backprop.momentum"),
+			Schedule:     trainingConfig.GetString("backprop.schedule"),
+			DecayRate:    trainingConfig.GetFloat64("backprop.decay_rate"),
+			DecaySteps:   trainingConfig.GetInt("backprop.decay_steps"),
+		},
+	}
+	if pipeline, err := newFeaturePipeline(settings.Features, settings.Lookback); err == nil {
+		settings.InputSize = pipeline.Width()
+	}
+	return settings
+}
+
+// Check the settings are usable
+func validateTrainingSettings(settings TrainingSettings) error {
+	pipeline, err := newFeaturePipeline(settings.Features, settings.Lookback)
+	if err != nil {
+		return err
+	}
+	if err := validateNormalization(settings.Normalization, settings.NormalizationWindow); err != nil {
+		return err
+	}
+	if err := validateRecurrent(settings.Recurrent, settings.RecurrentSize); err != nil {
+		return err
+	}
+	if settings.OutputSize != 3 {
+		return fmt.Errorf("output_size is %d but bots need exactly 3 outputs (nothing, buy, sell)", settings.OutputSize)
+	}
+	if len(settings.HiddenLayers) == 0 {
+		return errors.New("hidden_layers must have at least one layer")
+	}
+	for _, size := range settings.HiddenLayers {
+		if size <= 0 {
+			return errors.New("hidden_layers sizes must be above 0")
+		}
+	}
+	if err := checkActivations(withActivations(NeuralNet{HiddenLayers: make([][]Neuron, len(settings.HiddenLayers))}, settings.HiddenActivation, settings.OutputActivation)); err != nil {
+		return err
+	}
+	if settings.PopulationSize < 2 {
+		return errors.New("population_size must be at least 2")
+	}
+	if settings.EliteFraction <= 0 || settings.ImmigrantFraction < 0 || settings.EliteFraction+settings.ImmigrantFraction > 1 {
+		return errors.New("elite_fraction must be above 0 and elite_fraction + immigrant_fraction at most 1")
+	}
+	if settings.MutationMin < 0 || settings.MutationMax <= settings.MutationMin {
+		return errors.New("mutation_max must be above mutation_min, which must be at least 0")
+	}
+	if settings.TruncationFraction <= 0 || settings.TruncationFraction > 1 {
+		return errors.New("truncation_fraction must be above 0 and at most 1")
+	}
+	if _, err := getSelector(settings); err != nil {
+		return err
+	}
+	if _, err := getCrossover(settings.Crossover); err != nil {
+		return err
+	}
+	if settings.CrossoverRate < 0 || settings.CrossoverRate > 1 {
+		return errors.New("crossover_rate must be between 0 and 1")
+	}
+	if _, err := getLabeler(settings); err != nil {
+		return err
+	}
+	if _, err := getFitness(settings, BacktestConfig{}); err != nil {
+		return err
+	}
+	if settings.GenerationWindow <= 0 {
+		return errors.New("generation_window_hours must be above 0")
+	}
+	if settings.Workers < 0 {
+		return errors.New("workers must be at least 0")
+	}
+	if settings.WalkForward && settings.WalkForwardGenerations < 1 {
+		return errors.New("walk_forward_generations must be at least 1")
+	}
+	if _, err := getWindowStrategy(settings, nil, BotSettings{}, pipeline, nil, 0, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Convert a map of weights read from json, values that are not numbers are skipped
+func readWeights(values map[string]interface{}) map[string]float64 {
+	weights := make(map[string]float64)
+	for name, value := range values {
+		switch number := value.(type) {
+		case float64:
+			weights[name] = number
+		case int:
+			weights[name] = float64(number)
+		}
+	}
+	return weights
+}
+
+// Amount of bots kept as-is from the previous generation
+func eliteCount(settings TrainingSettings) int {
+	count := int(float64(settings.PopulationSize) * settings.EliteFraction)
+	if count < 1 {
+		return 1
+	}
+	return count
+}
+
+// Amount of fresh random bots added each generation
+func immigrantCount(settings TrainingSettings) int {
+	return int(float64(settings.PopulationSize) * settings.ImmigrantFraction)
+}
+
+// Feature pipeline of validated settings
+func trainingPipeline(settings TrainingSettings) FeaturePipeline {
+	pipeline, _ := newFeaturePipeline(settings.Features, settings.Lookback)
+	return pipeline
+}
+
+// Whether the settings put a recurrent layer in front of the hidden layers
+func hasRecurrent(settings TrainingSettings) bool {
+	return settings.Recurrent != "" && settings.Recurrent != "none"
+}
+
+// Create a random bot using the configured topology
+func randomBot(rng *rand.Rand, settings TrainingSettings) NeuralNet {
+	if hasRecurrent(settings) {
+		net := withActivations(RandomNet(rng, settings.RecurrentSize, len(settings.HiddenLayers), settings.HiddenLayers, settings.OutputSize), settings.HiddenActivation, settings.OutputActivation)
+		net.Recurrent = randomRecurrent(rng, settings.Recurrent, settings.InputSize, settings.RecurrentSize)
+		return net
+	}
+	return withActivations(RandomNet(rng, settings.InputSize, len(settings.HiddenLayers), settings.HiddenLayers, settings.OutputSize), settings.HiddenActivation, settings.OutputActivation)
+}
+
+// Layer sizes every bot must have under the given settings
+func trainingTopology(settings TrainingSettings) ModelTopology {
+	topology := ModelTopology{
+		InputSize:    settings.InputSize,
+		HiddenLayers: settings.HiddenLayers,
+		OutputSize:   settings.OutputSize,
+	}
+	if hasRecurrent(settings) {
+		topology.Recurrent = settings.Recurrent
+		topology.RecurrentSize = settings.RecurrentSize
+	}
+	return topology
+}
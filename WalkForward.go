@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Windows kept in the window cache before the oldest is dropped
+const windowCacheSize = 16
+
+// Loaded windows of recent generations, shared read-only between generations and workers
+type windowCache struct {
+	lock    sync.Mutex
+	windows map[string]TrainingWindow
+	order   []string
+}
+
+var loadedWindows = windowCache{windows: make(map[string]TrainingWindow)}
+
+// Load the history, inputs and labels of a window. Windows are cached by market, bounds, features and
+// labeler, so the database and features are only hit again once the window moves.
+func loadWindow(sql *sql.DB, settings BotSettings, pipeline FeaturePipeline, labeler Labeler, start int64, end int64) TrainingWindow {
+	key := fmt.Sprintf("%s|%d|%d|%s|%v|%d|%v", settings.Market, start, end, labeler.Name(), pipeline.Specs, pipeline.Lookback, pipeline.Normalizer)
+	loadedWindows.lock.Lock()
+	window, ok := loadedWindows.windows[key]
+	loadedWindows.lock.Unlock()
+	if ok {
+		return window
+	}
+	history := getHistory(sql, start, end, settings.Market)
+	window = TrainingWindow{
+		History: history,
+		Inputs:  pipeline.Transform(history),
+		Labels:  cachedLabels(labeler, settings.Market, start, end, history),
+	}
+	if len(history) == 0 { // Leave failed or empty loads to be retried
+		return window
+	}
+	loadedWindows.lock.Lock()
+	defer loadedWindows.lock.Unlock()
+	if _, ok := loadedWindows.windows[key]; !ok {
+		if len(loadedWindows.order) >= windowCacheSize {
+			delete(loadedWindows.windows, loadedWindows.order[0])
+			loadedWindows.order = loadedWindows.order[1:]
+		}
+		loadedWindows.windows[key] = window
+		loadedWindows.order = append(loadedWindows.order, key)
+	}
+	return window
+}
+
+// Out of sample window directly after the training window starting at cursor
+func validationBounds(cursor int64) (int64, int64) {
+	return cursor + training.GenerationWindow, cursor + 2*training.GenerationWindow
+}
+
+// Move the cursor forward by step, wrapping back to the start once the validation window would pass the end of the history
+func advanceCursor(cursor int64, step int64, marketStart int64, marketEnd int64) int64 {
+	cursor += step
+	if _, validationEnd := validationBounds(cursor); validationEnd > marketEnd {
+		return marketStart
+	}
+	return cursor
+}
+
+// Get the latest point of the markets history
+func getMarketEndPoint(sql *sql.DB, market string) int64 {
+	query, err := sql.Query("SELECT MAX(timestamp) FROM market_data WHERE market='" + market + "'")
+	if err != nil {
+		println(err.Error())
+	}
+	lastTimestamp := time.Now().Unix()
+	if query != nil && query.Next() {
+		err = query.Scan(&lastTimestamp)
+		if err != nil {
+			println(err.Error())
+		}
+	}
+	return lastTimestamp
+}
+
+// Load the out of sample window for the training window starting at cursor
+func loadValidationWindow(sql *sql.DB, settings BotSettings, pipeline FeaturePipeline, labeler Labeler, cursor int64) TrainingWindow {
+	start, end := validationBounds(cursor)
+	return loadWindow(sql, settings, pipeline, labeler, start, end)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math/rand"
+)
+
+// Candidate windows drawn per sample when stratifying by regime
+const regimeCandidates = 4
+
+// Decides which slices of the market history a generation is scored on
+type WindowStrategy interface {
+	// Windows the population is scored on, the score of a bot is its average fitness over them
+	Windows(rng *rand.Rand, cursor int64) []TrainingWindow
+	// Cursor to use after the given generation finished
+	Advance(cursor int64, generation int) int64
+	// History the input normalizer is fitted on, covering every window the strategy can return from the cursor
+	FitBounds(cursor int64) (int64, int64)
+}
+
+// History shared by every strategy
+type marketRange struct {
+	sql      *sql.DB
+	settings BotSettings
+	pipeline FeaturePipeline
+	labeler  Labeler
+	start    int64
+	end      int64
+}
+
+// Always score on the window at the cursor
+type fixedWindows struct {
+	marketRange
+}
+
+// Score on the window at the cursor, moving the cursor forward by step every generation
+type sequentialWindows struct {
+	marketRange
+	step int64
+}
+
+// Score on 'samples' windows drawn uniformly from the history before the validation window
+type randomWindows struct {
+	marketRange
+	samples int
+}
+
+// Like random windows, but spread evenly over bull, bear and sideways markets
+type regimeWindows struct {
+	marketRange
+	samples   int
+	threshold float64 // Return a window needs to count as bull or bear
+}
+
+// Create the configured window strategy
+func getWindowStrategy(settings TrainingSettings, sql *sql.DB, botSettings BotSettings, pipeline FeaturePipeline, labeler Labeler, marketStart int64, marketEnd int64) (WindowStrategy, error) {
+	history := marketRange{sql: sql, settings: botSettings, pipeline: pipeline, labeler: labeler, start: marketStart, end: marketEnd}
+	switch settings.WindowStrategy {
+	case "fixed", "": // Checkpoints from before window strategies existed
+		return fixedWindows{history}, nil
+	case "sequential":
+		if settings.WindowStep <= 0 {
+			return nil, errors.New("window_step_hours must be above 0")
+		}
+		return sequentialWindows{history, settings.WindowStep}, nil
+	case "random":
+		if settings.WindowSamples < 1 {
+			return nil, errors.New("window_samples must be at least 1")
+		}
+		return randomWindows{history, settings.WindowSamples}, nil
+	case "regime":
+		if settings.WindowSamples < 1 {
+			return nil, errors.New("window_samples must be at least 1")
+		}
+		if settings.RegimeThreshold <= 0 {
+			return nil, errors.New("regime_threshold must be above 0")
+		}
+		return regimeWindows{history, settings.WindowSamples, settings.RegimeThreshold}, nil
+	}
+	return nil, errors.New("unknown window_strategy '" + settings.WindowStrategy + "'")
+}
+
+// With walk-forward the cursor rolls to the next fold every few generations, otherwise it stays put
+func (history marketRange) Advance(cursor int64, generation int) int64 {
+	if training.WalkForward && generation%training.WalkForwardGenerations == 0 {
+		return advanceCursor(cursor, training.GenerationWindow, history.start, history.end)
+	}
+	return cursor
+}
+
+// Normalizer fitted on the history the strategy can score on from the cursor
+func fitWindowNormalizer(sql *sql.DB, settings BotSettings, strategy WindowStrategy, cursor int64) Normalizer {
+	start, end := strategy.FitBounds(cursor)
+	return trainingPipeline(training).Fit(training, getHistory(sql, start, end, settings.Market)).Normalizer
+}
+
+// Only the window at the cursor is scored on
+func (history marketRange) FitBounds(cursor int64) (int64, int64) {
+	return cursor, cursor + training.GenerationWindow
+}
+
+// Union of every window that can be sampled, which stays clear of the validation window
+func (history marketRange) sampledBounds(cursor int64) (int64, int64) {
+	return history.start, history.latestStart(cursor) + training.GenerationWindow
+}
+
+// Latest start a sampled window can have, keeping it clear of the validation window
+func (history marketRange) latestStart(cursor int64) int64 {
+	latest := history.end - training.GenerationWindow
+	if training.WalkForward {
+		latest = cursor
+	}
+	if latest < history.start {
+		return history.start
+	}
+	return latest
+}
+
+// Random start of a window
+func (history marketRange) sampleStart(rng *rand.Rand, cursor int64) int64 {
+	return history.start + rng.Int63n(history.latestStart(cursor)-history.start+1)
+}
+
+func (strategy fixedWindows) Windows(rng *rand.Rand, cursor int64) []TrainingWindow {
+	return []TrainingWindow{loadWindow(strategy.sql, strategy.settings, strategy.pipeline, strategy.labeler, cursor, cursor+training.GenerationWindow)}
+}
+
+func (strategy sequentialWindows) Windows(rng *rand.Rand, cursor int64) []TrainingWindow {
+	return []TrainingWindow{loadWindow(strategy.sql, strategy.settings, strategy.pipeline, strategy.labeler, cursor, cursor+training.GenerationWindow)}
+}
+
+func (strategy sequentialWindows) Advance(cursor int64, generation int) int64 {
+	return advanceCursor(cursor, strategy.step, strategy.start, strategy.end)
+}
+
+func (strategy randomWindows) Windows(rng *rand.Rand, cursor int64) []TrainingWindow {
+	windows := make([]TrainingWindow, strategy.samples)
+	for index := range windows {
+		start := strategy.sampleStart(rng, cursor)
+		windows[index] = loadWindow(strategy.sql, strategy.settings, strategy.pipeline, strategy.labeler, start, start+training.GenerationWindow)
+	}
+	return windows
+}
+
+func (strategy randomWindows) FitBounds(cursor int64) (int64, int64) {
+	return strategy.sampledBounds(cursor)
+}
+
+func (strategy regimeWindows) Windows(rng *rand.Rand, cursor int64) []TrainingWindow {
+	// Sort candidate windows into bull, bear and sideways
+	type candidate struct {
+		start   int64
+		history []HistoricalEntry
+	}
+	regimes := make([][]candidate, 3)
+	for x := 0; x < strategy.samples*regimeCandidates; x++ {
+		start := strategy.sampleStart(rng, cursor)
+		history := getHistory(strategy.sql, start, start+training.GenerationWindow, strategy.settings.Market)
+		regime := 2
+		if change := windowReturn(history); change >= strategy.threshold {
+			regime = 0
+		} else if change <= -strategy.threshold {
+			regime = 1
+		}
+		regimes[regime] = append(regimes[regime], candidate{start: start, history: history})
+	}
+	// Take from each regime in turn, markets that never had a regime leave their turn to the others
+	windows := make([]TrainingWindow, 0, strategy.samples)
+	for taken := 0; len(windows) < strategy.samples; taken++ {
+		for regime := range regimes {
+			if taken < len(regimes[regime]) && len(windows) < strategy.samples {
+				picked := regimes[regime][taken]
+				windows = append(windows, TrainingWindow{
+					History: picked.history,
+					Inputs:  strategy.pipeline.Transform(picked.history),
+					Labels:  cachedLabels(strategy.labeler, strategy.settings.Market, picked.start, picked.start+training.GenerationWindow, picked.history),
+				})
+			}
+		}
+	}
+	return windows
+}
+
+func (strategy regimeWindows) FitBounds(cursor int64) (int64, int64) {
+	return strategy.sampledBounds(cursor)
+}
+
+// Change in price from the first to the last entry of a window
+func windowReturn(history []HistoricalEntry) float64 {
+	if len(history) == 0 {
+		return 0
+	}
+	first := history[0]
+	last := history[0]
+	for _, entry := range history {
+		if entry.timestamp < first.timestamp {
+			first = entry
+		}
+		if entry.timestamp > last.timestamp {
+			last = entry
+		}
+	}
+	if first.firstTradePrice <= 0 {
+		return 0
+	}
+	return last.lastTradePrice/first.firstTradePrice - 1
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestFitBoundsFollowCursor(t *testing.T) {
+	saved := training
+	defer func() { training = saved }()
+	training = TrainingSettings{GenerationWindow: 3600, WalkForward: true, WalkForwardGenerations: 1, WindowStep: 1800, WindowSamples: 2, RegimeThreshold: 0.02}
+	tests := []struct {
+		strategy string
+		next     int64 // Cursor after the first generation
+		first    [2]int64
+		moved    [2]int64
+	}{
+		{"fixed", 3600, [2]int64{0, 3600}, [2]int64{3600, 7200}},
+		{"sequential", 1800, [2]int64{0, 3600}, [2]int64{1800, 5400}},
+		// Sampled windows end where the validation window after the cursor starts
+		{"random", 3600, [2]int64{0, 3600}, [2]int64{0, 7200}},
+		{"regime", 3600, [2]int64{0, 3600}, [2]int64{0, 7200}},
+	}
+	for _, test := range tests {
+		training.WindowStrategy = test.strategy
+		strategy, err := getWindowStrategy(training, nil, BotSettings{}, FeaturePipeline{}, nil, 0, 100000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := strategy.Advance(0, 1)
+		if next != test.next {
+			t.Fatalf("%s: cursor moved to %d, expected %d", test.strategy, next, test.next)
+		}
+		if start, end := strategy.FitBounds(0); start != test.first[0] || end != test.first[1] {
+			t.Errorf("%s: fitted on %d to %d at the start, expected %v", test.strategy, start, end, test.first)
+		}
+		if start, end := strategy.FitBounds(next); start != test.moved[0] || end != test.moved[1] {
+			t.Errorf("%s: fitted on %d to %d after moving, expected %v", test.strategy, start, end, test.moved)
+		}
+	}
+}
+
+func TestFitBoundsWithoutWalkForward(t *testing.T) {
+	saved := training
+	defer func() { training = saved }()
+	training = TrainingSettings{GenerationWindow: 3600, WindowStrategy: "random", WindowSamples: 2}
+	strategy, err := getWindowStrategy(training, nil, BotSettings{}, FeaturePipeline{}, nil, 0, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every window of the history can be sampled
+	if start, end := strategy.FitBounds(0); start != 0 || end != 100000 {
+		t.Fatalf("fitted on %d to %d, expected the whole history", start, end)
+	}
+}
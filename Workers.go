@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// Cancel functions of the training runs in progress, by bot name
+var trainingRuns = struct {
+	lock    sync.Mutex
+	cancels map[string]context.CancelFunc
+}{cancels: make(map[string]context.CancelFunc)}
+
+// Register a training run so it can be stopped, call the returned function once it finished
+func trackTraining(name string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	trainingRuns.lock.Lock()
+	trainingRuns.cancels[name] = cancel
+	trainingRuns.lock.Unlock()
+	return ctx, func() {
+		trainingRuns.lock.Lock()
+		delete(trainingRuns.cancels, name)
+		trainingRuns.lock.Unlock()
+		cancel()
+	}
+}
+
+// Stop a training run after its current generation is abandoned
+func stopTraining(name string) error {
+	trainingRuns.lock.Lock()
+	defer trainingRuns.lock.Unlock()
+	cancel, ok := trainingRuns.cancels[name]
+	if !ok {
+		return errors.New("no training running for " + name)
+	}
+	cancel()
+	return nil
+}
+
+// Amount of bots evaluated at once, 0 uses every core
+func workerCount(settings TrainingSettings) int {
+	if settings.Workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return settings.Workers
+}
+
+// Score every bot on the windows using a fixed amount of workers. The windows are shared between the workers
+// and only ever read, each bot is imported for inference once and reused for every window. Returns the context
+// error if it was cancelled before every bot was scored.
+func evaluatePopulation(ctx context.Context, workers int, bots []NeuralNet, windows []TrainingWindow, fitness FitnessFunction, single bool) ([]BotGenerationScore, error) {
+	botScores := make([]BotGenerationScore, len(bots))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	if workers > len(bots) {
+		workers = len(bots)
+	}
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				botScores[index] = BotGenerationScore{
+					Bot:   bots[index],
+					score: averageFitness(fitness, newBatchNet(bots[index], single), windows),
+				}
+			}
+		}()
+	}
+queue:
+	for index := range bots {
+		select {
+		case jobs <- index:
+		case <-ctx.Done():
+			break queue
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return botScores, nil
+}